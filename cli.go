@@ -0,0 +1,755 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"github.com/gszr/dot/pkg/dot"
+)
+
+/*
+ * command line: subcommands, their flags, help and shell completion
+ */
+
+const (
+	exitOk      = 0
+	exitFailure = 1 // nothing could be applied
+	exitUsage   = 2
+	exitInvalid = 3 // the dots file is invalid
+	exitPartial = 4 // some entries failed, the others were applied or in place
+	exitDrift   = 5 // status found the machine drifted from the dots file
+)
+
+type command struct {
+	name  string
+	args  string
+	short string
+	flags func(fs *flag.FlagSet)
+	run   func(args []string) int
+	// completes positional arguments: "files", "commands" or a fixed list
+	complete []string
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{
+			name:  "apply",
+			short: "map files and fetch resources (the default command)",
+			flags: applyFlags,
+			run:   runApply,
+		},
+		{
+			name:  "plan",
+			short: "print the actions apply would perform, without performing them",
+			flags: commonFlags,
+			run:   runPlan,
+		},
+		{
+			name:  "validate",
+			short: "only read and validate the dots file",
+			flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&flagDotFile, "dot", flagDotFile, "the dots config file")
+				outputFlag(fs)
+				varsFlags(fs)
+			},
+			run: runValidate,
+		},
+		{
+			name:  "fetch",
+			short: "only fetch resources",
+			flags: applyFlags,
+			run:   runFetch,
+		},
+		{
+			name:  "unlink",
+			short: "remove the targets dot created, without creating them again",
+			flags: commonFlags,
+			run:   runUnlink,
+		},
+		{
+			name:  "status",
+			short: "report drift between the machine and the dots file",
+			flags: func(fs *flag.FlagSet) {
+				commonFlags(fs)
+				outputFlag(fs)
+			},
+			run: runStatus,
+		},
+		{
+			name:  "diff",
+			short: "print how applying would change mapped files",
+			flags: commonFlags,
+			run:   runDiff,
+		},
+		{
+			name:  "facts",
+			short: "print the facts about this machine templates can use",
+			flags: outputFlag,
+			run:   runFacts,
+		},
+		{
+			name:     "adopt",
+			args:     "<path>...",
+			short:    "move existing files into the source tree and map them back",
+			flags:    commonFlags,
+			run:      runAdopt,
+			complete: []string{"files"},
+		},
+		{
+			name:  "restore",
+			args:  "[backup]",
+			short: "restore the files from the latest (or the given) backup",
+			flags: commonFlags,
+			run:   runRestore,
+		},
+		{
+			name:  "version",
+			short: "print version info",
+			run: func(args []string) int {
+				printVersionInfo()
+				return exitOk
+			},
+		},
+		{
+			name:     "completion",
+			args:     "<bash|zsh|fish>",
+			short:    "print a shell completion script",
+			run:      runCompletion,
+			complete: []string{"bash", "zsh", "fish"},
+		},
+		{
+			name:     "help",
+			args:     "[command|templates]",
+			short:    "print help for dot, one of its commands or templates",
+			run:      runHelp,
+			complete: []string{"commands", "templates"},
+		},
+	}
+}
+
+func commonFlags(fs *flag.FlagSet) {
+	fs.StringVar(&flagDotFile, "dot", flagDotFile, "the dots config file")
+	fs.BoolVar(&flagVerbose, "verbose", flagVerbose, "verbose output")
+	varsFlags(fs)
+}
+
+func varsFlags(fs *flag.FlagSet) {
+	fs.Var(&flagVars, "set", "set a template variable, name=value (repeatable)")
+}
+
+// varsFlag collects -set assignments, checking each as it is given
+type varsFlag []string
+
+func (v *varsFlag) String() string {
+	return strings.Join(*v, ",")
+}
+
+func (v *varsFlag) Set(assignment string) error {
+	if _, err := dot.ParseVars([]string{assignment}); err != nil {
+		return err
+	}
+	*v = append(*v, assignment)
+	return nil
+}
+
+func outputFlag(fs *flag.FlagSet) {
+	fs.StringVar(&flagOutput, "output", flagOutput, "output format, text or json")
+}
+
+func applyFlags(fs *flag.FlagSet) {
+	commonFlags(fs)
+	outputFlag(fs)
+	fs.BoolVar(&flagKeepGoing, "keep-going", flagKeepGoing, "keep applying after a failure instead of rolling back")
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func (cmd *command) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("dot "+cmd.name, flag.ContinueOnError)
+	fs.SetOutput(logger.Writer())
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	fs.Usage = func() {
+		cmd.printUsage(fs.Output(), fs)
+	}
+	return fs
+}
+
+func (cmd *command) printUsage(w io.Writer, fs *flag.FlagSet) {
+	usage := "usage: dot " + cmd.name
+	hasFlags := false
+	fs.VisitAll(func(*flag.Flag) {
+		hasFlags = true
+	})
+	if hasFlags {
+		usage += " [flags]"
+	}
+	if len(cmd.args) > 0 {
+		usage += " " + cmd.args
+	}
+	fmt.Fprintf(w, "%s\n\n%s\n", usage, cmd.short)
+	if hasFlags {
+		fmt.Fprintf(w, "\nflags:\n")
+		fs.PrintDefaults()
+	}
+}
+
+func (cmd *command) execute(args []string) int {
+	fs := cmd.flagSet()
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOk
+		}
+		return exitUsage
+	}
+	if !isOutputFormat(flagOutput) {
+		logger.Printf("unknown output format %s, must be text or json\n", flagOutput)
+		return exitUsage
+	}
+	return cmd.run(fs.Args())
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "usage: dot [command] [flags]\n\na simple dot file manager\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-11s %s\n", cmd.name, cmd.short)
+	}
+	fmt.Fprintf(w, "\nrun `dot help <command>` for the flags a command takes, and `dot help templates`\nfor what templates can use\n")
+}
+
+// legacyCommand maps the flags of the flat command line dot used to have
+// onto the command they stand for
+func legacyCommand() string {
+	switch {
+	case flagV:
+		return "version"
+	case flagValidateOnly:
+		return "validate"
+	case flagDryRun:
+		return "plan"
+	case flagRm && flagRmOnly:
+		return "unlink"
+	}
+	return "apply"
+}
+
+func runCLI(args []string) int {
+	// a bare `dot`, possibly with the old flat flags, still applies
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		flag.CommandLine.Init("dot", flag.ContinueOnError)
+		flag.CommandLine.SetOutput(logger.Writer())
+		flag.CommandLine.Usage = func() {
+			printUsage(logger.Writer())
+		}
+		if err := flag.CommandLine.Parse(args); err != nil {
+			if err == flag.ErrHelp {
+				return exitOk
+			}
+			return exitUsage
+		}
+		args = flag.Args()
+		if !isOutputFormat(flagOutput) {
+			logger.Printf("unknown output format %s, must be text or json\n", flagOutput)
+			return exitUsage
+		}
+		if len(args) == 0 {
+			return findCommand(legacyCommand()).run(nil)
+		}
+	}
+
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		logger.Printf("unknown command %s\n", args[0])
+		printUsage(logger.Writer())
+		return exitUsage
+	}
+	return cmd.execute(args[1:])
+}
+
+// load reads the dots file, with the variables set on the command line
+func load() (dot.Dots, error) {
+	vars, err := dot.ParseVars(flagVars)
+	if err != nil {
+		return dot.Dots{}, err
+	}
+	return dot.LoadWithVars(flagDotFile, vars)
+}
+
+// loadDots reads the dots file and the state; when either fails, it reports
+// why and returns the code to exit with
+func loadDots() (dot.Dots, *dot.State, int) {
+	dots, err := load()
+	if err != nil {
+		reportInvalid(err)
+		return dot.Dots{}, nil, exitInvalid
+	}
+	reportWarnings(dots)
+	st, err := dot.LoadState(dot.StatePath())
+	if err != nil {
+		logger.Printf("failed loading state: %v\n", err)
+		return dot.Dots{}, nil, exitFailure
+	}
+	return dots, st, exitOk
+}
+
+// options are the library's options as set by the command line flags
+func options() dot.Options {
+	o := dot.Options{
+		Verbose:   flagVerbose,
+		NoRm:      !flagRm,
+		Unlink:    flagRm && flagRmOnly,
+		FetchOnly: flagFetchOnly,
+		KeepGoing: flagKeepGoing,
+		Logger:    logger,
+	}
+	if flagOutput == outputText {
+		o.Progress = os.Stderr
+	}
+	return o
+}
+
+func exitCode(r dot.Report) int {
+	switch {
+	case len(r.Failures) == 0:
+		return exitOk
+	case r.RolledBack || r.Applied+r.Unchanged == 0:
+		return exitFailure
+	}
+	return exitPartial
+}
+
+func reportInvalid(err error) {
+	if flagOutput == outputJson {
+		writeValidation(os.Stdout, err, nil)
+		return
+	}
+	logger.Printf("%v\n", err)
+}
+
+func reportWarnings(dots dot.Dots) {
+	for _, warning := range dots.Warnings {
+		logger.Printf("warning: %s\n", warning)
+	}
+}
+
+func runApply(args []string) int {
+	dots, st, code := loadDots()
+	if code != exitOk {
+		return code
+	}
+	// interrupting rolls back what was applied so far
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	r := dots.Apply(ctx, st, options())
+	if flagOutput == outputJson {
+		writeReport(os.Stdout, r)
+		return exitCode(r)
+	}
+	for _, f := range r.Failures {
+		logger.Printf("%v\n", f.Err)
+	}
+	logger.Println(r)
+	return exitCode(r)
+}
+
+func runFetch(args []string) int {
+	flagFetchOnly = true
+	return runApply(args)
+}
+
+func runUnlink(args []string) int {
+	flagRm, flagRmOnly = true, true
+	return runApply(args)
+}
+
+func runPlan(args []string) int {
+	dots, st, code := loadDots()
+	if code != exitOk {
+		return code
+	}
+	for _, action := range dots.Plan(st, options()) {
+		if action.Kind != dot.ActionUnchanged || flagVerbose {
+			fmt.Println(action)
+		}
+	}
+	return exitOk
+}
+
+func runValidate(args []string) int {
+	dots, err := load()
+	if err != nil {
+		reportInvalid(err)
+		return exitInvalid
+	}
+	if flagOutput == outputJson {
+		writeValidation(os.Stdout, nil, dots.Warnings)
+	} else {
+		reportWarnings(dots)
+		logger.Printf("yay, dots file valid!")
+	}
+	return exitOk
+}
+
+func runStatus(args []string) int {
+	dots, st, code := loadDots()
+	if code != exitOk {
+		return code
+	}
+	drift := false
+	for _, status := range dots.Status(context.Background(), st) {
+		if flagOutput == outputJson {
+			writeStatus(os.Stdout, status)
+		} else {
+			fmt.Println(status)
+		}
+		drift = drift || status.IsDrift()
+	}
+	if drift {
+		return exitDrift
+	}
+	return exitOk
+}
+
+func runDiff(args []string) int {
+	dots, st, code := loadDots()
+	if code != exitOk {
+		return code
+	}
+	// exit codes follow diff(1): 1 if there are differences, 2 on errors
+	diffs, errs := dots.Diff(st)
+	for _, diff := range diffs {
+		fmt.Print(diff)
+	}
+	for _, err := range errs {
+		logger.Printf("failed diffing %v\n", err)
+	}
+	if len(errs) > 0 {
+		return 2
+	}
+	if len(diffs) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func runFacts(args []string) int {
+	facts := dot.GatherFacts()
+	if flagOutput == outputJson {
+		writeFacts(os.Stdout, facts)
+		return exitOk
+	}
+	for _, fact := range facts.Fields() {
+		fmt.Printf("%-21s %s\n", ".Facts."+fact.Name, fact.Value)
+	}
+	return exitOk
+}
+
+func runAdopt(args []string) int {
+	if len(args) == 0 {
+		findCommand("adopt").flagSet().Usage()
+		return exitUsage
+	}
+	dots, st, code := loadDots()
+	if code != exitOk {
+		return code
+	}
+	for _, target := range args {
+		if err := dots.Adopt(flagDotFile, target, st, options()); err != nil {
+			logger.Printf("%v\n", err)
+			return exitFailure
+		}
+		// later paths must see the mappings added so far
+		var err error
+		if dots, err = load(); err != nil {
+			logger.Printf("%v\n", err)
+			return exitInvalid
+		}
+	}
+	return exitOk
+}
+
+func runRestore(args []string) int {
+	dots, st, code := loadDots()
+	if code != exitOk {
+		return code
+	}
+	stamp := ""
+	if len(args) > 0 {
+		stamp = args[0]
+	}
+	if err := dots.Restore(stamp, st, options()); err != nil {
+		logger.Printf("failed restoring backups: %v\n", err)
+		return exitFailure
+	}
+	return exitOk
+}
+
+func runHelp(args []string) int {
+	if len(args) == 0 {
+		printUsage(os.Stdout)
+		return exitOk
+	}
+	if args[0] == "templates" {
+		printTemplatesHelp(os.Stdout)
+		return exitOk
+	}
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		logger.Printf("unknown command %s\n", args[0])
+		return exitUsage
+	}
+	fs := cmd.flagSet()
+	fs.SetOutput(os.Stdout)
+	cmd.printUsage(os.Stdout, fs)
+	return exitOk
+}
+
+const templatesHelp = `templates
+
+The values in a mapping's "with", and the files of mappings that have one (or
+"render: true"), are Go templates (https://pkg.go.dev/text/template). They see:
+
+  .Os       the OS dot runs on, as in runtime.GOOS
+  .Facts    facts about the machine, as listed by "dot facts"
+  .<name>   the variables ("vars"), and in files, the mapping's "with" values
+
+Besides text/template's own functions (eq, ne, and, or, not, len, index,
+printf, ...), templates can call:
+
+`
+
+func printTemplatesHelp(w io.Writer) {
+	fmt.Fprint(w, templatesHelp)
+	for _, f := range dot.TemplateFuncs {
+		fmt.Fprintf(w, "  %-24s %s\n", f.Usage, f.Doc)
+	}
+	fmt.Fprintf(w, "\nfor instance, {{ .Editor | default \"vi\" }} or {{ if hasCommand \"nvim\" }}...{{ end }}\n")
+}
+
+func runCompletion(args []string) int {
+	if len(args) != 1 {
+		findCommand("completion").flagSet().Usage()
+		return exitUsage
+	}
+	script, err := completionScript(args[0])
+	if err != nil {
+		logger.Printf("%v\n", err)
+		return exitUsage
+	}
+	fmt.Print(script)
+	return exitOk
+}
+
+/*
+ * shell completion, generated from the commands above
+ */
+
+// flagValues lists what the values of flags complete to, the same way
+// command.complete does for positional arguments; values of flags not
+// listed complete to nothing
+var flagValues = map[string][]string{
+	"dot":    {"files"},
+	"output": {outputText, outputJson},
+}
+
+type flagInfo struct {
+	name   string
+	usage  string
+	isBool bool
+	values []string
+}
+
+func (cmd *command) flagInfos() []flagInfo {
+	var infos []flagInfo
+	cmd.flagSet().VisitAll(func(f *flag.Flag) {
+		boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool })
+		infos = append(infos, flagInfo{f.Name, f.Usage, ok && boolFlag.IsBoolFlag(), flagValues[f.Name]})
+	})
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].name < infos[j].name
+	})
+	return infos
+}
+
+func commandNames() []string {
+	var names []string
+	for _, cmd := range commands {
+		names = append(names, cmd.name)
+	}
+	return names
+}
+
+// positionals resolves what a command's positional arguments complete to
+func (cmd *command) positionals() (files bool, words []string) {
+	return completions(cmd.complete)
+}
+
+// completions resolves a completion list: "files", "commands" or words
+func completions(complete []string) (files bool, words []string) {
+	for _, c := range complete {
+		switch c {
+		case "files":
+			files = true
+		case "commands":
+			words = append(words, commandNames()...)
+		default:
+			words = append(words, c)
+		}
+	}
+	return files, words
+}
+
+func completionScript(shell string) (string, error) {
+	switch shell {
+	case "bash":
+		return bashCompletion(), nil
+	case "zsh":
+		return zshCompletion(), nil
+	case "fish":
+		return fishCompletion(), nil
+	}
+	return "", fmt.Errorf("unsupported shell %s, must be one of bash, zsh or fish", shell)
+}
+
+func bashCompletion() string {
+	var b strings.Builder
+	b.WriteString("# bash completion for dot\n_dot() {\n")
+	b.WriteString("    local cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	fmt.Fprintf(&b, "    if [ \"$COMP_CWORD\" -eq 1 ]; then\n        COMPREPLY=($(compgen -W \"%s\" -- \"$cur\"))\n        return\n    fi\n",
+		strings.Join(commandNames(), " "))
+	b.WriteString("    case \"${COMP_WORDS[1]}\" in\n")
+	for _, cmd := range commands {
+		var flags, values []string
+		for _, f := range cmd.flagInfos() {
+			flags = append(flags, "-"+f.name)
+			if f.isBool {
+				continue
+			}
+			reply := "()"
+			if files, words := completions(f.values); files {
+				reply = "($(compgen -f -- \"$cur\"))"
+			} else if len(words) > 0 {
+				reply = fmt.Sprintf("($(compgen -W \"%s\" -- \"$cur\"))", strings.Join(words, " "))
+			}
+			values = append(values, fmt.Sprintf("            -%s) COMPREPLY=%s; return ;;\n", f.name, reply))
+		}
+		files, words := cmd.positionals()
+		fmt.Fprintf(&b, "    %s)\n", cmd.name)
+		if len(values) > 0 {
+			fmt.Fprintf(&b, "        case \"$prev\" in\n%s        esac\n", strings.Join(values, ""))
+		}
+		if len(flags) > 0 {
+			fmt.Fprintf(&b, "        if [[ \"$cur\" == -* ]]; then COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")); return; fi\n",
+				strings.Join(flags, " "))
+		}
+		if files {
+			b.WriteString("        COMPREPLY=($(compgen -f -- \"$cur\"))\n")
+		}
+		if len(words) > 0 {
+			fmt.Fprintf(&b, "        COMPREPLY=($(compgen -W \"%s\" -- \"$cur\"))\n", strings.Join(words, " "))
+		}
+		b.WriteString("        ;;\n")
+	}
+	b.WriteString("    esac\n}\ncomplete -F _dot dot\n")
+	return b.String()
+}
+
+func zshEscape(s string) string {
+	r := strings.NewReplacer("'", "'\\''", "[", "\\[", "]", "\\]", ":", "\\:")
+	return r.Replace(s)
+}
+
+func zshCompletion() string {
+	var b strings.Builder
+	b.WriteString("#compdef dot\n\n_dot() {\n    local -a commands\n    commands=(\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(&b, "        '%s:%s'\n", cmd.name, zshEscape(cmd.short))
+	}
+	b.WriteString("    )\n    if (( CURRENT == 2 )); then\n        _describe 'command' commands\n        return\n    fi\n")
+	b.WriteString("    local cmd=\"$words[2]\"\n    shift words\n    (( CURRENT-- ))\n    case \"$cmd\" in\n")
+	for _, cmd := range commands {
+		var specs []string
+		for _, f := range cmd.flagInfos() {
+			spec := fmt.Sprintf("'-%s[%s]", f.name, zshEscape(f.usage))
+			files, words := completions(f.values)
+			switch {
+			case f.isBool:
+			case files:
+				spec += ":file:_files"
+			case len(words) > 0:
+				spec += fmt.Sprintf(":%s:(%s)", f.name, strings.Join(words, " "))
+			default:
+				spec += fmt.Sprintf(":%s: ", f.name)
+			}
+			specs = append(specs, spec+"'")
+		}
+		files, words := cmd.positionals()
+		if files {
+			specs = append(specs, "'*:path:_files'")
+		}
+		if len(words) > 0 {
+			specs = append(specs, fmt.Sprintf("':argument:(%s)'", strings.Join(words, " ")))
+		}
+		fmt.Fprintf(&b, "        %s)\n", cmd.name)
+		if len(specs) > 0 {
+			fmt.Fprintf(&b, "            _arguments %s\n", strings.Join(specs, " "))
+		}
+		b.WriteString("            ;;\n")
+	}
+	b.WriteString("    esac\n}\n\n_dot \"$@\"\n")
+	return b.String()
+}
+
+func fishEscape(s string) string {
+	return strings.ReplaceAll(s, "'", "\\'")
+}
+
+func fishCompletion() string {
+	var b strings.Builder
+	b.WriteString("# fish completion for dot\ncomplete -c dot -f\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(&b, "complete -c dot -n __fish_use_subcommand -a %s -d '%s'\n", cmd.name, fishEscape(cmd.short))
+	}
+	for _, cmd := range commands {
+		cond := "'__fish_seen_subcommand_from " + cmd.name + "'"
+		for _, f := range cmd.flagInfos() {
+			line := fmt.Sprintf("complete -c dot -n %s -o %s -d '%s'", cond, f.name, fishEscape(f.usage))
+			files, words := completions(f.values)
+			switch {
+			case f.isBool:
+			case files:
+				line += " -r -F"
+			case len(words) > 0:
+				line += fmt.Sprintf(" -r -a '%s'", strings.Join(words, " "))
+			default:
+				line += " -r"
+			}
+			b.WriteString(line + "\n")
+		}
+		files, words := cmd.positionals()
+		if files {
+			fmt.Fprintf(&b, "complete -c dot -n %s -F\n", cond)
+		}
+		if len(words) > 0 {
+			fmt.Fprintf(&b, "complete -c dot -n %s -a '%s'\n", cond, strings.Join(words, " "))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gszr/dot/pkg/dot"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLegacyCommand(t *testing.T) {
+	defer func() {
+		flagV, flagValidateOnly, flagDryRun, flagRm, flagRmOnly = false, false, false, true, false
+	}()
+
+	assert.Equal(t, "apply", legacyCommand())
+	flagRmOnly = true
+	assert.Equal(t, "unlink", legacyCommand())
+	flagDryRun = true
+	assert.Equal(t, "plan", legacyCommand())
+	flagValidateOnly = true
+	assert.Equal(t, "validate", legacyCommand())
+	flagV = true
+	assert.Equal(t, "version", legacyCommand())
+}
+
+func TestRunCLI(t *testing.T) {
+	defer func() {
+		flagV, flagDotFile, flagOutput, flagVars = false, "dot.yml", outputText, nil
+	}()
+	assert.Equal(t, 0, runCLI([]string{"version"}))
+	assert.Equal(t, 0, runCLI([]string{"-v"}))
+	assert.Equal(t, 0, runCLI([]string{"validate", "-dot", "examples/01-dots-basic.yml"}))
+	assert.Equal(t, 0, runCLI([]string{"help", "apply"}))
+	assert.Equal(t, 0, runCLI([]string{"facts"}))
+	assert.Equal(t, 0, runCLI([]string{"help", "templates"}))
+	assert.Equal(t, 0, runCLI([]string{"apply", "-h"}))
+	assert.Equal(t, 2, runCLI([]string{"nonexistent"}))
+	assert.Equal(t, 2, runCLI([]string{"status", "-rm-only"}))
+	assert.Equal(t, 2, runCLI([]string{"completion", "powershell"}))
+	assert.Equal(t, 2, runCLI([]string{"adopt"}))
+	assert.Equal(t, exitInvalid, runCLI([]string{"validate", "-dot", "examples/nonexistent.yml"}))
+	assert.Equal(t, exitInvalid, runCLI([]string{"apply", "-dot", "examples/nonexistent.yml"}))
+	assert.Equal(t, 0, runCLI([]string{"validate", "-dot", "examples/01-dots-basic.yml", "-set", "email=me@home.org", "-set", "git.signing=true"}))
+	assert.Equal(t, 2, runCLI([]string{"validate", "-set", "email"}))
+
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	assert.Equal(t, exitDrift, runCLI([]string{"status", "-dot", "examples/01-dots-basic.yml"}))
+}
+
+func TestRunCLIUnknownOutput(t *testing.T) {
+	t.Cleanup(func() {
+		flagOutput = outputText
+	})
+	assert.Equal(t, 2, runCLI([]string{"validate", "-output", "yaml"}))
+}
+
+func TestCommandFlags(t *testing.T) {
+	for _, cmd := range commands {
+		assert.NotEmpty(t, cmd.short, cmd.name)
+		assert.NotNil(t, cmd.run, cmd.name)
+	}
+
+	var names []string
+	for _, f := range findCommand("apply").flagInfos() {
+		names = append(names, f.name)
+	}
+	assert.Equal(t, []string{"dot", "keep-going", "output", "set", "verbose"}, names)
+	assert.Empty(t, findCommand("version").flagInfos())
+}
+
+func TestCompletionScript(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		script, err := completionScript(shell)
+		assert.Nil(t, err)
+		for _, cmd := range commands {
+			assert.True(t, strings.Contains(script, cmd.name), "%s: %s", shell, cmd.name)
+		}
+		assert.True(t, strings.Contains(script, "keep-going"), shell)
+	}
+
+	// only -dot completes file names
+	bash, _ := completionScript("bash")
+	assert.True(t, strings.Contains(bash, "complete -F _dot dot"))
+	assert.True(t, strings.Contains(bash, "-dot) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;"))
+	assert.True(t, strings.Contains(bash, "-output) COMPREPLY=($(compgen -W \"text json\" -- \"$cur\")); return ;;"))
+	assert.True(t, strings.Contains(bash, "-set) COMPREPLY=(); return ;;"))
+	zsh, _ := completionScript("zsh")
+	assert.True(t, strings.HasPrefix(zsh, "#compdef dot\n"))
+	assert.True(t, strings.Contains(zsh, "'-dot[the dots config file]:file:_files'"))
+	assert.True(t, strings.Contains(zsh, "'-output[output format, text or json]:output:(text json)'"))
+	assert.True(t, strings.Contains(zsh, "'-set[set a template variable, name=value (repeatable)]:set: '"))
+	fish, _ := completionScript("fish")
+	assert.True(t, strings.Contains(fish, "complete -c dot -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish'"))
+	assert.True(t, strings.Contains(fish, "-o output -d 'output format, text or json' -r -a 'text json'\n"))
+	assert.True(t, strings.Contains(fish, "-o set -d 'set a template variable, name=value (repeatable)' -r\n"))
+
+	_, err := completionScript("powershell")
+	assert.NotNil(t, err)
+}
+
+func TestOptions(t *testing.T) {
+	defer func() {
+		flagRm, flagRmOnly, flagOutput = true, false, outputText
+	}()
+
+	o := options()
+	assert.False(t, o.NoRm)
+	assert.False(t, o.Unlink)
+	assert.NotNil(t, o.Progress)
+
+	// rm-only does nothing without rm
+	flagRm, flagRmOnly, flagOutput = false, true, outputJson
+	o = options()
+	assert.True(t, o.NoRm)
+	assert.False(t, o.Unlink)
+	assert.Nil(t, o.Progress)
+}
+
+func TestExitCode(t *testing.T) {
+	failed := []dot.Failure{{Entry: "zshrc", Err: errors.New("failed")}}
+	assert.Equal(t, exitOk, exitCode(dot.Report{Applied: 2, Unchanged: 1}))
+	assert.Equal(t, exitPartial, exitCode(dot.Report{Applied: 2, Failures: failed}))
+	assert.Equal(t, exitPartial, exitCode(dot.Report{Unchanged: 4, Failures: failed}))
+	assert.Equal(t, exitFailure, exitCode(dot.Report{Failures: failed}))
+	assert.Equal(t, exitFailure, exitCode(dot.Report{Failures: failed, RolledBack: true}))
+}
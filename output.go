@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gszr/dot/pkg/dot"
+)
+
+/*
+ * output: machine readable (-output json) results, one JSON object per line
+ */
+
+const (
+	outputText = "text"
+	outputJson = "json"
+)
+
+type jsonAction struct {
+	Type     string  `json:"type"`
+	Action   string  `json:"action"`
+	Source   string  `json:"source,omitempty"`
+	Dest     string  `json:"dest"`
+	As       string  `json:"as,omitempty"`
+	Result   string  `json:"result"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration_ms"`
+}
+
+type jsonFailure struct {
+	Entry string `json:"entry"`
+	Error string `json:"error"`
+}
+
+type jsonSummary struct {
+	Type       string        `json:"type"`
+	Applied    int           `json:"applied"`
+	Unchanged  int           `json:"unchanged"`
+	Failed     int           `json:"failed"`
+	RolledBack bool          `json:"rolled_back"`
+	ExitCode   int           `json:"exit_code"`
+	Failures   []jsonFailure `json:"failures"`
+}
+
+type jsonValidation struct {
+	Type     string   `json:"type"`
+	Valid    bool     `json:"valid"`
+	Errors   []string `json:"errors"`
+	Warnings []string `json:"warnings"`
+}
+
+type jsonStatus struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	Source string `json:"source,omitempty"`
+	Dest   string `json:"dest"`
+	Detail string `json:"detail,omitempty"`
+	Drift  bool   `json:"drift"`
+}
+
+type jsonFacts struct {
+	Type string `json:"type"`
+	dot.Facts
+}
+
+func isOutputFormat(format string) bool {
+	return format == outputText || format == outputJson
+}
+
+func writeJSON(w io.Writer, v interface{}) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		logger.Printf("failed encoding output: %v\n", err)
+		return
+	}
+	fmt.Fprintf(w, "%s\n", out)
+}
+
+// writeReport writes an event per action run, followed by the summary
+func writeReport(w io.Writer, r dot.Report) {
+	for _, result := range r.Actions {
+		action := jsonAction{
+			Type:     "action",
+			Action:   result.Kind,
+			Source:   result.From,
+			Dest:     result.To,
+			As:       result.As(),
+			Result:   result.Result,
+			Duration: float64(result.Duration.Microseconds()) / 1000,
+		}
+		if result.Err != nil {
+			action.Error = result.Err.Error()
+		}
+		writeJSON(w, action)
+	}
+	summary := jsonSummary{
+		Type:       "summary",
+		Applied:    r.Applied,
+		Unchanged:  r.Unchanged,
+		Failed:     len(r.Failures),
+		RolledBack: r.RolledBack,
+		ExitCode:   exitCode(r),
+		Failures:   []jsonFailure{},
+	}
+	for _, f := range r.Failures {
+		summary.Failures = append(summary.Failures, jsonFailure{f.Entry, f.Err.Error()})
+	}
+	writeJSON(w, summary)
+}
+
+// writeValidation writes the outcome of reading the dots file; err is
+// either nil, a dot.ValidationError listing everything wrong with it, or why
+// it could not be read at all
+func writeValidation(w io.Writer, err error, warnings []string) {
+	v := jsonValidation{Type: "validation", Valid: err == nil, Errors: []string{}, Warnings: []string{}}
+	v.Warnings = append(v.Warnings, warnings...)
+	var verr dot.ValidationError
+	if errors.As(err, &verr) {
+		for _, e := range verr.Errs {
+			v.Errors = append(v.Errors, e.Error())
+		}
+	} else if err != nil {
+		v.Errors = append(v.Errors, err.Error())
+	}
+	writeJSON(w, v)
+}
+
+func writeStatus(w io.Writer, s dot.Status) {
+	writeJSON(w, jsonStatus{
+		Type:   "status",
+		Status: s.Status,
+		Source: s.From,
+		Dest:   s.To,
+		Detail: s.Detail,
+		Drift:  s.IsDrift(),
+	})
+}
+
+func writeFacts(w io.Writer, f dot.Facts) {
+	writeJSON(w, jsonFacts{Type: "facts", Facts: f})
+}
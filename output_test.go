@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gszr/dot/pkg/dot"
+	"github.com/stretchr/testify/assert"
+)
+
+func decodeLines(t *testing.T, out string) []map[string]interface{} {
+	var objs []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		var obj map[string]interface{}
+		assert.Nil(t, json.Unmarshal([]byte(line), &obj), line)
+		objs = append(objs, obj)
+	}
+	return objs
+}
+
+func newTestState(t *testing.T) *dot.State {
+	st, err := dot.LoadState(filepath.Join(t.TempDir(), "state.json"))
+	assert.Nil(t, err)
+	return st
+}
+
+func TestWriteReport(t *testing.T) {
+	to := "out/"
+	assert.Nil(t, os.MkdirAll(to, 0750))
+	defer func() {
+		_ = os.RemoveAll(to)
+	}()
+
+	d := dot.Dots{
+		FileMappings: []dot.FileMapping{
+			{From: "examples/zshrc", To: "out/zshrc", As: "link"},
+			{From: "examples/nonexistent", To: "out/other", As: "copy"},
+		},
+	}
+	var out bytes.Buffer
+	writeReport(&out, d.Apply(context.Background(), newTestState(t), dot.Options{KeepGoing: true}))
+
+	objs := decodeLines(t, out.String())
+	assert.Equal(t, 3, len(objs))
+	assert.Equal(t, "action", objs[0]["type"])
+	assert.Equal(t, dot.ActionLink, objs[0]["action"])
+	assert.Equal(t, "examples/zshrc", objs[0]["source"])
+	assert.Equal(t, "out/zshrc", objs[0]["dest"])
+	assert.Equal(t, "link", objs[0]["as"])
+	assert.Equal(t, dot.ResultOk, objs[0]["result"])
+	assert.Contains(t, objs[0], "duration_ms")
+	assert.Equal(t, dot.ResultFailed, objs[1]["result"])
+	assert.NotEmpty(t, objs[1]["error"])
+
+	summary := objs[2]
+	assert.Equal(t, "summary", summary["type"])
+	assert.Equal(t, float64(1), summary["applied"])
+	assert.Equal(t, float64(1), summary["failed"])
+	assert.Equal(t, float64(exitPartial), summary["exit_code"])
+	assert.Equal(t, "examples/nonexistent", summary["failures"].([]interface{})[0].(map[string]interface{})["entry"])
+}
+
+func TestWriteValidation(t *testing.T) {
+	var out bytes.Buffer
+	writeValidation(&out, dot.ValidationError{Errs: []error{errors.New("a: path does not exist"), errors.New("b: path does not exist")}}, nil)
+	writeValidation(&out, errors.New("cannot decode data"), nil)
+	writeValidation(&out, nil, nil)
+	writeValidation(&out, nil, []string{"config/*.conf: glob matches nothing"})
+	assert.Equal(t, `{"type":"validation","valid":false,"errors":["a: path does not exist","b: path does not exist"],"warnings":[]}
+{"type":"validation","valid":false,"errors":["cannot decode data"],"warnings":[]}
+{"type":"validation","valid":true,"errors":[],"warnings":[]}
+{"type":"validation","valid":true,"errors":[],"warnings":["config/*.conf: glob matches nothing"]}
+`, out.String())
+}
+
+func TestWriteFacts(t *testing.T) {
+	var out bytes.Buffer
+	writeFacts(&out, dot.Facts{Os: "linux", Arch: "arm64", Hostname: "box", Distro: "debian", DistroVersion: "12", Cpus: 4})
+	assert.Equal(t, `{"type":"facts","os":"linux","arch":"arm64","hostname":"box","username":"","home":"","distro":"debian","distro_version":"12","kernel":"","cpus":4,"shell":""}`+"\n", out.String())
+}
+
+func TestWriteStatus(t *testing.T) {
+	var out bytes.Buffer
+	writeStatus(&out, dot.Status{Status: dot.StatusWrongLinkTarget, From: "a", To: "b", Detail: "points to c"})
+	assert.Equal(t, `{"type":"status","status":"wrong-link-target","source":"a","dest":"b","detail":"points to c","drift":true}`+"\n", out.String())
+}
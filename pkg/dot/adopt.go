@@ -0,0 +1,187 @@
+package dot
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+ * adopt: move existing files into the dots source tree and map them back
+ */
+
+// sourceName picks the name under `opt.cd` that maps back to target; `to`
+// is only returned when the destination cannot be inferred from the name
+func sourceName(target string) (name, to string) {
+	rel, err := filepath.Rel(getHomeDir(), target)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return filepath.Base(target), target
+	}
+	if strings.HasPrefix(rel, ".") {
+		return strings.TrimPrefix(rel, "."), ""
+	}
+	return rel, "~/" + rel
+}
+
+func yamlScalar(s string) string {
+	out, err := yaml.Marshal(s)
+	if err != nil {
+		return s
+	}
+	return strings.TrimSuffix(string(out), "\n")
+}
+
+// appendMapping adds an entry at the end of the dots file's `map` section,
+// editing it as text so that everything else is preserved as is
+func appendMapping(data []byte, name, to string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	entry := func(indent string) string {
+		e := indent + yamlScalar(name) + ":\n"
+		if len(to) > 0 {
+			e += indent + indent + "to: " + yamlScalar(to) + "\n"
+		}
+		return e
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		lines[len(lines)-1] += "\n"
+	}
+	insert := func(at int, text string) []byte {
+		var out []string
+		out = append(out, lines[:at]...)
+		out = append(out, text)
+		out = append(out, lines[at:]...)
+		return []byte(strings.Join(out, ""))
+	}
+
+	if len(doc.Content) == 0 {
+		return insert(len(lines), "map:\n"+entry("  ")), nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("dots file is not a map")
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "map" {
+			continue
+		}
+		files := root.Content[i+1]
+		if files.Kind == yaml.ScalarNode && files.Tag == "!!null" {
+			return insert(root.Content[i].Line, entry("  ")), nil
+		}
+		if files.Kind != yaml.MappingNode || files.Style&yaml.FlowStyle != 0 {
+			return nil, fmt.Errorf("`map` must be a block mapping to be edited")
+		}
+		for j := 0; j+1 < len(files.Content); j += 2 {
+			if files.Content[j].Value == name {
+				return nil, fmt.Errorf("%s: already mapped", name)
+			}
+		}
+
+		// the section ends right before the next top-level key, minus the
+		// blank lines and comments preceding that key
+		end := len(lines)
+		if i+2 < len(root.Content) {
+			end = root.Content[i+2].Line - 1
+		}
+		for end > files.Content[len(files.Content)-2].Line {
+			line := lines[end-1]
+			if len(strings.TrimSpace(line)) > 0 && !strings.HasPrefix(line, "#") {
+				break
+			}
+			end--
+		}
+		indent := strings.Repeat(" ", files.Content[0].Column-1)
+		return insert(end, entry(indent)), nil
+	}
+
+	return insert(len(lines), "\nmap:\n"+entry("  ")), nil
+}
+
+// Adopt moves target into the source tree, adds a mapping for it to the
+// dots file and links it back in place
+func (dots Dots) Adopt(dotFile, target string, st *State, o Options) error {
+	target = absPath(expandTilde(target))
+	if !pathExists(target) {
+		return fmt.Errorf("%s: path does not exist", target)
+	}
+	if isSymlink(target) {
+		return fmt.Errorf("%s: is a symlink", target)
+	}
+	for _, mapping := range dots.FileMappings {
+		if absPath(mapping.To) == target {
+			return fmt.Errorf("%s: already mapped from %s", target, mapping.From)
+		}
+	}
+
+	name, to := sourceName(target)
+	source := absPath(filepath.Join(dots.Opts.Cd, name))
+	if pathExists(source) {
+		return fmt.Errorf("%s: already exists", source)
+	}
+	patterns, err := dots.Opts.ignorePatterns()
+	if err != nil {
+		return err
+	}
+	if dots.Opts.newIgnorer(patterns, nil, ".").ignores(source, isDirectory(target)) {
+		return fmt.Errorf("%s: ignored by %s", source, ignoreFile)
+	}
+
+	data, err := os.ReadFile(dotFile)
+	if err != nil {
+		return err
+	}
+	fileInfo, err := os.Stat(dotFile)
+	if err != nil {
+		return err
+	}
+	newData, err := appendMapping(data, name, to)
+	if err != nil {
+		return fmt.Errorf("failed editing %s: %v", dotFile, err)
+	}
+
+	tx := begin(st, o, absPath(dotFile), newBackupStamp(dots.Opts.backupRoot()))
+	err = func() error {
+		tx.creating(source)
+		if err := createPath(source); err != nil {
+			return err
+		}
+		if err := os.Rename(target, source); err != nil {
+			return err
+		}
+		tx.onUndo(func() error {
+			return os.Rename(source, target)
+		})
+
+		if err := os.WriteFile(dotFile, newData, fileInfo.Mode().Perm()); err != nil {
+			return err
+		}
+		tx.onUndo(func() error {
+			return os.WriteFile(dotFile, data, fileInfo.Mode().Perm())
+		})
+
+		m := FileMapping{From: source, To: target, As: "link", Relative: dots.Opts.Relative}
+		return Action{Kind: ActionLink, From: m.From, To: m.To, mapping: &m}.run(context.Background(), tx)
+	}()
+	if err != nil {
+		tx.rollback(0)
+		return fmt.Errorf("failed adopting %s: %v", target, err)
+	}
+	tx.commit()
+
+	o.logf("adopted %s as %s\n", target, source)
+	return nil
+}
@@ -0,0 +1,143 @@
+package dot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSourceName(t *testing.T) {
+	t.Setenv("HOME", "/home/me")
+	cases := map[string][2]string{
+		"/home/me/.zshrc":       {"zshrc", ""},
+		"/home/me/.config/nvim": {"config/nvim", ""},
+		"/home/me/bin/foo":      {"bin/foo", "~/bin/foo"},
+		"/etc/hosts":            {"hosts", "/etc/hosts"},
+	}
+	for in, want := range cases {
+		name, to := sourceName(in)
+		assert.Equal(t, want[0], name, in)
+		assert.Equal(t, want[1], to, in)
+		if len(to) == 0 {
+			assert.Equal(t, in, inferDestination(name))
+		}
+	}
+}
+
+func TestAppendMapping(t *testing.T) {
+	cases := []struct {
+		in, name, to, want string
+	}{
+		{
+			in: `# my dots
+
+map:
+  # shell
+  zshrc:
+    os: linux # only there
+
+# options
+opt:
+  cd: dots/
+`,
+			name: "tmux.conf",
+			want: `# my dots
+
+map:
+  # shell
+  zshrc:
+    os: linux # only there
+  tmux.conf:
+
+# options
+opt:
+  cd: dots/
+`,
+		},
+		{
+			in:   "opt:\n    cd: dots\nmap:\n    zshrc:\n    gitconfig:",
+			name: "bin/foo",
+			to:   "~/bin/foo",
+			want: "opt:\n    cd: dots\nmap:\n    zshrc:\n    gitconfig:\n    bin/foo:\n        to: ~/bin/foo\n",
+		},
+		{
+			in:   "map:\nopt:\n  cd: dots\n",
+			name: "zshrc",
+			want: "map:\n  zshrc:\nopt:\n  cd: dots\n",
+		},
+		{
+			in:   "opt:\n  cd: dots\n",
+			name: "zshrc",
+			want: "opt:\n  cd: dots\n\nmap:\n  zshrc:\n",
+		},
+		{
+			in:   "",
+			name: "a: b",
+			want: "map:\n  'a: b':\n",
+		},
+	}
+	for _, c := range cases {
+		got, err := appendMapping([]byte(c.in), c.name, c.to)
+		assert.Nil(t, err)
+		assert.Equal(t, c.want, string(got))
+	}
+
+	_, err := appendMapping([]byte("map:\n  zshrc:\n"), "zshrc", "")
+	assert.NotNil(t, err)
+	_, err = appendMapping([]byte("map: {zshrc: }\n"), "gitconfig", "")
+	assert.NotNil(t, err)
+}
+
+func TestAdopt(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	repo := t.TempDir()
+	dotFile := filepath.Join(repo, "dot.yml")
+	assert.Nil(t, os.WriteFile(dotFile, []byte("# mine\nmap:\n  zshrc:\n\nopt:\n  cd: "+repo+"/dots\n"), 0644))
+	assert.Nil(t, os.MkdirAll(filepath.Join(repo, "dots"), 0750))
+	assert.Nil(t, os.WriteFile(filepath.Join(repo, "dots", "zshrc"), []byte("zsh"), 0644))
+	assert.Nil(t, os.MkdirAll(filepath.Join(home, ".config", "nvim"), 0750))
+	assert.Nil(t, os.WriteFile(filepath.Join(home, ".config", "nvim", "init.lua"), []byte("lua"), 0644))
+
+	dots, err := Load(dotFile)
+	assert.Nil(t, err)
+	st := newTestState(t)
+	assert.Nil(t, dots.Adopt(dotFile, "~/.config/nvim", st, Options{}))
+
+	// moved into the source tree and linked back
+	source := filepath.Join(repo, "dots", "config", "nvim")
+	assert.Equal(t, "lua", readString(t, filepath.Join(source, "init.lua")))
+	dst, err := os.Readlink(filepath.Join(home, ".config", "nvim"))
+	assert.Nil(t, err)
+	assert.Equal(t, source, dst)
+	assert.True(t, st.owns(filepath.Join(home, ".config", "nvim")))
+	assert.Equal(t, "# mine\nmap:\n  zshrc:\n  config/nvim:\n\nopt:\n  cd: "+repo+"/dots\n", readString(t, dotFile))
+
+	// maps back to the same place
+	dots, err = Load(dotFile)
+	assert.Nil(t, err)
+	assert.Equal(t, StatusOk, dots.FileMappings[1].status(st).Status)
+
+	// nothing to adopt, or already adopted
+	assert.NotNil(t, dots.Adopt(dotFile, "~/.nonexistent", st, Options{}))
+	assert.NotNil(t, dots.Adopt(dotFile, "~/.config/nvim", st, Options{}))
+}
+
+func TestAdoptLeavesFilesOnError(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	repo := t.TempDir()
+	dotFile := filepath.Join(repo, "dot.yml")
+	assert.Nil(t, os.WriteFile(dotFile, []byte("map: {zshrc: }\nopt:\n  cd: "+repo+"\n"), 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(repo, "zshrc"), []byte("zsh"), 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(home, ".tmux.conf"), []byte("tmux"), 0644))
+
+	// cannot edit flow style maps
+	dots, err := Load(dotFile)
+	assert.Nil(t, err)
+	assert.NotNil(t, dots.Adopt(dotFile, filepath.Join(home, ".tmux.conf"), newTestState(t), Options{}))
+	assert.Equal(t, "tmux", readString(t, filepath.Join(home, ".tmux.conf")))
+	assert.False(t, pathExists(filepath.Join(repo, "tmux.conf")))
+}
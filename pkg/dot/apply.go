@@ -0,0 +1,391 @@
+package dot
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*
+ * applying: actions run inside a transaction that can be rolled back
+ */
+
+// transaction journals every change made to the filesystem and the state,
+// so that it can be reverted; removed targets are kept aside until commit
+type transaction struct {
+	st    *State
+	o     Options
+	dots  string
+	stamp string
+	undo  []func() error
+	trash []string
+}
+
+// begin starts a transaction recording what it creates as owned by the
+// dots file at path dots; stamp tells what it moves aside from what other
+// transactions do
+func begin(st *State, o Options, dots, stamp string) *transaction {
+	return &transaction{st: st, o: o, dots: dots, stamp: stamp}
+}
+
+func (tx *transaction) onUndo(undo func() error) {
+	tx.undo = append(tx.undo, undo)
+}
+
+func (tx *transaction) savepoint() int {
+	return len(tx.undo)
+}
+
+// rollback reverts, newest first, every change made since the savepoint
+func (tx *transaction) rollback(savepoint int) []error {
+	var errs []error
+	for i := len(tx.undo) - 1; i >= savepoint; i-- {
+		if err := tx.undo[i](); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	tx.undo = tx.undo[:savepoint]
+	return errs
+}
+
+func (tx *transaction) commit() {
+	for _, trash := range tx.trash {
+		if err := os.RemoveAll(trash); err != nil {
+			tx.o.logf("failed removing %s, %v\n", trash, err)
+		}
+	}
+	tx.undo = nil
+	tx.trash = nil
+}
+
+func (tx *transaction) trashPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".dot-"+tx.stamp)
+}
+
+// unmapPath moves path aside; it's only gone for good once the transaction
+// commits
+func (tx *transaction) unmapPath(path string) error {
+	if !pathExists(path) {
+		tx.o.debugf("rm %s: skipping, file not there\n", path)
+		return nil
+	}
+	trash := tx.trashPath(path)
+	if err := os.Rename(path, trash); err != nil {
+		return fmt.Errorf("failed removing file %s, %v", path, err)
+	}
+	tx.trash = append(tx.trash, trash)
+	tx.onUndo(func() error {
+		return os.Rename(trash, path)
+	})
+	tx.o.debugf("rm %s: success\n", path)
+	return tx.forget(path)
+}
+
+func (tx *transaction) backup(dir, target, backup string) error {
+	if err := backupTarget(dir, target, backup); err != nil {
+		return fmt.Errorf("failed backing up %s: %v", target, err)
+	}
+	tx.onUndo(func() error {
+		if err := movePath(backup, target); err != nil {
+			return err
+		}
+		entries, err := readBackupIndex(dir)
+		if err != nil || len(entries) == 0 {
+			return err
+		}
+		// a backup left with nothing in it would be the one restored
+		if len(entries) == 1 {
+			return os.RemoveAll(dir)
+		}
+		return writeBackupIndex(dir, entries[:len(entries)-1])
+	})
+	tx.o.logf("backed up %s to %s\n", target, backup)
+	return tx.forget(target)
+}
+
+// creating registers the removal of target, and of any directory created
+// to hold it, should the transaction be rolled back
+func (tx *transaction) creating(target string) {
+	if pathExists(target) {
+		return
+	}
+	created := firstMissingDir(filepath.Dir(target))
+	tx.onUndo(func() error {
+		if len(created) > 0 {
+			return os.RemoveAll(created)
+		}
+		return os.RemoveAll(target)
+	})
+}
+
+// updating keeps a copy of target, a directory about to be updated in
+// place, to put back should the transaction be rolled back
+func (tx *transaction) updating(target string) error {
+	kept := tx.trashPath(target)
+	if err := cloneTree(target, kept); err != nil {
+		_ = os.RemoveAll(kept)
+		return fmt.Errorf("failed keeping a copy of %s, %v", target, err)
+	}
+	tx.trash = append(tx.trash, kept)
+	tx.onUndo(func() error {
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+		return os.Rename(kept, target)
+	})
+	return nil
+}
+
+func (tx *transaction) record(target string, entry StateEntry) error {
+	entry.Dots = tx.dots
+	prev, ok := tx.st.Targets[absPath(target)]
+	tx.onUndo(func() error {
+		if ok {
+			tx.st.Targets[absPath(target)] = prev
+			return tx.st.save()
+		}
+		return tx.st.forget(target)
+	})
+	return tx.st.record(target, entry)
+}
+
+func (tx *transaction) forget(target string) error {
+	prev, ok := tx.st.Targets[absPath(target)]
+	if !ok {
+		return nil
+	}
+	tx.onUndo(func() error {
+		tx.st.Targets[absPath(target)] = prev
+		return tx.st.save()
+	})
+	return tx.st.forget(target)
+}
+
+func (a Action) run(ctx context.Context, tx *transaction) error {
+	switch a.Kind {
+	case ActionRemove, ActionOverwrite, ActionPrune:
+		if a.tree != nil {
+			return tx.unlinkTreeFile(a)
+		}
+		entry := tx.st.Targets[absPath(a.To)]
+		if a.Kind != ActionOverwrite && entry.As == "tree" && isRealDirectory(a.To) {
+			return tx.unfoldTree(a.To)
+		}
+		if err := tx.unmapPath(a.To); err != nil {
+			return err
+		}
+		// the rendering a link led to goes along with it
+		if entry.As == "link" && isRendering(entry.Source) {
+			return tx.unmapPath(entry.Source)
+		}
+		return nil
+	case ActionBackup:
+		return tx.backup(a.backupDir, a.From, a.To)
+	case ActionFail:
+		return fmt.Errorf("%s: %s", a.To, a.Reason)
+	case ActionForget:
+		return tx.forget(a.To)
+	case ActionLink, ActionHardlink, ActionCopy, ActionRender:
+		if a.tree != nil {
+			return tx.linkTreeFile(a)
+		}
+		tx.creating(a.To)
+		if a.mapping.syncsInPlace(tx.st) {
+			if err := tx.updating(a.To); err != nil {
+				return err
+			}
+		}
+		changed, err := a.mapping.domap(tx.st, tx.o)
+		if err != nil || !changed {
+			return err
+		}
+		return tx.record(a.To, StateEntry{Source: a.source(), As: a.mapping.As})
+	case ActionClone, ActionDownload:
+		tx.creating(a.To)
+		if err := fetchResource(ctx, *a.resource, tx.o); err != nil {
+			return fmt.Errorf("error fetching resource %s, %v", a.resource.Url, err)
+		}
+		return tx.record(a.To, StateEntry{Source: a.From, As: a.resource.As})
+	case ActionHook:
+		return a.runHook(ctx, tx.o)
+	case ActionRun:
+		return a.runScript(ctx, tx)
+	case ActionSkip:
+		tx.o.debugf("skipping %s: %s\n", a.From, a.Reason)
+	case ActionUnchanged:
+		// already in place, possibly from before dot kept state
+		if a.script != nil {
+			return nil
+		}
+		if prev, ok := tx.st.Targets[absPath(a.To)]; ok {
+			// recorded before dot noted which dots file created what
+			if prev.Dots == "" && tx.dots != "" {
+				return tx.record(a.To, prev)
+			}
+		} else {
+			entry := StateEntry{Source: a.source(), As: a.As()}
+			if entry.As == "tree" {
+				// every link is there, so dot may as well have made them
+				files, err := a.mapping.treeFiles()
+				if err != nil {
+					return err
+				}
+				entry.Files = files
+			}
+			return tx.record(a.To, entry)
+		}
+	}
+	return nil
+}
+
+/*
+ * reporting: what a run did
+ */
+
+const (
+	ResultOk         = "ok"
+	ResultFailed     = "failed"
+	ResultRolledBack = "rolled-back"
+)
+
+// ActionResult is what running a single action did
+type ActionResult struct {
+	Action
+	Result   string
+	Err      error
+	Duration time.Duration
+}
+
+// Failure is an entry, named after its source, that could not be applied
+type Failure struct {
+	Entry string
+	Err   error
+}
+
+type Report struct {
+	Actions    []ActionResult
+	Applied    int
+	Unchanged  int
+	Failures   []Failure
+	RolledBack bool
+}
+
+func (r Report) String() string {
+	summary := fmt.Sprintf("%d applied, %d unchanged, %d failed", r.Applied, r.Unchanged, len(r.Failures))
+	if r.RolledBack {
+		summary += "; rolled back all changes"
+	}
+	return summary
+}
+
+// rollBack marks the actions that succeeded, from the given one on, as
+// rolled back
+func (r *Report) rollBack(from int) {
+	for i := from; i < len(r.Actions); i++ {
+		if r.Actions[i].Result == ResultOk {
+			r.Actions[i].Result = ResultRolledBack
+		}
+	}
+}
+
+func entryName(entry []Action) string {
+	for _, action := range entry {
+		switch {
+		case action.tree != nil:
+			return action.tree.From
+		case action.mapping != nil:
+			return action.mapping.From
+		case action.resource != nil:
+			return action.resource.Url
+		case action.script != nil:
+			return action.script.Name
+		}
+	}
+	action := entry[len(entry)-1]
+	if len(action.From) > 0 {
+		return action.From
+	}
+	return action.To
+}
+
+// isRunHook reports whether the entry is one of the run's own hooks, which
+// are not entries of the dots file
+func isRunHook(entry []Action) bool {
+	return len(entry) == 1 && entry[0].Kind == ActionHook
+}
+
+func isNoop(entry []Action) bool {
+	for _, action := range entry {
+		if action.Kind != ActionUnchanged && action.Kind != ActionSkip && action.Kind != ActionHook {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply runs the dots file's actions as a single transaction: on the first
+// failure, everything done so far is rolled back. With o.KeepGoing, a
+// failure only rolls back the entry it happened in, and the remaining
+// entries are still applied; the report lists every failure. Scripts always
+// behave that way: what they did cannot be rolled back, and a failing one
+// does not undo unrelated entries. Cancelling ctx fails the entry being
+// applied
+func (dots Dots) Apply(ctx context.Context, st *State, o Options) Report {
+	dots.Opts.stamp = newBackupStamp(dots.Opts.backupRoot())
+	var entries [][]Action
+	for _, entry := range dots.planEntries(st, o) {
+		entries = append(entries, resolveConflicts(entry, o.ask))
+	}
+
+	tx := begin(st, o, dots.File, dots.Opts.stamp)
+	var r Report
+	for _, entry := range entries {
+		savepoint := tx.savepoint()
+		first := len(r.Actions)
+		var err error
+		for _, action := range entry {
+			if err = ctx.Err(); err != nil {
+				r.Actions = append(r.Actions, ActionResult{Action: action, Result: ResultFailed, Err: err})
+				break
+			}
+			start := time.Now()
+			err = action.run(ctx, tx)
+			result := ActionResult{Action: action, Result: ResultOk, Err: err, Duration: time.Since(start)}
+			if err != nil {
+				result.Result = ResultFailed
+			}
+			r.Actions = append(r.Actions, result)
+			if err != nil {
+				break
+			}
+		}
+		if err == nil {
+			switch {
+			case isRunHook(entry):
+			case isNoop(entry):
+				r.Unchanged++
+			default:
+				r.Applied++
+			}
+			continue
+		}
+
+		f := Failure{Entry: entryName(entry), Err: err}
+		if !o.KeepGoing && !isScript(entry) {
+			for _, rbErr := range tx.rollback(0) {
+				o.logf("failed rolling back: %v\n", rbErr)
+			}
+			r.rollBack(0)
+			return Report{Actions: r.Actions, Failures: append(r.Failures, f), RolledBack: true}
+		}
+		for _, rbErr := range tx.rollback(savepoint) {
+			o.logf("failed rolling back: %v\n", rbErr)
+		}
+		r.rollBack(first)
+		r.Failures = append(r.Failures, f)
+	}
+	tx.commit()
+	return r
+}
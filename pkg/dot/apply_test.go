@@ -0,0 +1,162 @@
+package dot
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readString(t *testing.T, path string) string {
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	return string(content)
+}
+
+func TestFirstMissingDir(t *testing.T) {
+	assert.Equal(t, "", firstMissingDir("examples"))
+	assert.Equal(t, "examples/a", firstMissingDir("examples/a/b/c"))
+}
+
+func TestApplyRollsBackOnFailure(t *testing.T) {
+	to := "out/"
+	assert.Nil(t, createPath(to))
+	defer func() {
+		_ = os.RemoveAll(to)
+	}()
+	assert.Nil(t, os.WriteFile("out/zshrc", []byte("mine"), 0644))
+	assert.Nil(t, os.WriteFile("out/gitconfig", []byte("mine too"), 0644))
+
+	st := newTestState(t)
+	backups := t.TempDir()
+	d := Dots{
+		Opts: Opts{Backup: backups},
+		FileMappings: []FileMapping{
+			{From: "examples/zshrc", To: "out/zshrc", As: "link"},
+			{From: "examples/zshrc", To: "out/new/dir/zshrc", As: "copy"},
+			// fails after its destination was backed up
+			{From: "examples/nonexistent", To: "out/gitconfig", As: "copy"},
+		},
+	}
+
+	r := d.Apply(context.Background(), st, Options{})
+	assert.Equal(t, 1, len(r.Failures))
+	assert.True(t, r.RolledBack)
+
+	// everything is as it was
+	assert.False(t, isSymlink("out/zshrc"))
+	assert.Equal(t, "mine", readString(t, "out/zshrc"))
+	assert.Equal(t, "mine too", readString(t, "out/gitconfig"))
+	assert.False(t, pathExists("out/new"))
+	trash, err := filepath.Glob("out/.zshrc.dot-*")
+	assert.Nil(t, err)
+	assert.Empty(t, trash)
+	assert.Empty(t, st.Targets)
+	loaded, err := LoadState(st.path)
+	assert.Nil(t, err)
+	assert.Empty(t, loaded.Targets)
+
+	// nor is there an empty backup to restore
+	backed, err := os.ReadDir(backups)
+	assert.Nil(t, err)
+	assert.Empty(t, backed)
+	_, err = latestBackup(backups)
+	assert.NotNil(t, err)
+}
+
+func TestApplyRollsBackRemovals(t *testing.T) {
+	to := "out/"
+	assert.Nil(t, createPath(to))
+	defer func() {
+		_ = os.RemoveAll(to)
+	}()
+
+	st := newTestState(t)
+	m := FileMapping{From: "examples/gitconfig", To: "out/gitconfig", As: "copy"}
+	_, err := m.domap(st, Options{})
+	assert.Nil(t, err)
+	assert.Nil(t, st.record(m.To, StateEntry{Source: m.From, As: m.As}))
+
+	d := Dots{
+		FileMappings: []FileMapping{
+			// removed, since dot owns it
+			{From: "examples/zshrc", To: "out/gitconfig", As: "copy"},
+			{From: "examples/zshrc", To: "out/zshrc", As: "link", Conflict: ConflictFail},
+		},
+	}
+	assert.Nil(t, os.WriteFile("out/zshrc", []byte("mine"), 0644))
+
+	r := d.Apply(context.Background(), st, Options{})
+	assert.Equal(t, 1, len(r.Failures))
+	assert.True(t, r.RolledBack)
+	assert.Equal(t, readString(t, "examples/gitconfig"), readString(t, "out/gitconfig"))
+	assert.True(t, st.owns("out/gitconfig"))
+}
+
+func TestApplyKeepGoing(t *testing.T) {
+	to := "out/"
+	assert.Nil(t, createPath(to))
+	defer func() {
+		_ = os.RemoveAll(to)
+	}()
+	assert.Nil(t, os.WriteFile("out/gitconfig", []byte("mine"), 0644))
+
+	st := newTestState(t)
+	d := Dots{
+		Opts: Opts{Backup: t.TempDir()},
+		FileMappings: []FileMapping{
+			{From: "examples/nonexistent", To: "out/gitconfig", As: "copy"},
+			{From: "examples/zshrc", To: "out/zshrc", As: "link"},
+			{From: "examples/nonexistent", To: "out/other", As: "copy"},
+		},
+	}
+
+	r := d.Apply(context.Background(), st, Options{KeepGoing: true})
+	assert.Equal(t, 2, len(r.Failures))
+	assert.Equal(t, 1, r.Applied)
+	assert.Equal(t, absPath("examples/nonexistent"), absPath(r.Failures[0].Entry))
+
+	// failed entries are rolled back, the others applied
+	assert.Equal(t, "mine", readString(t, "out/gitconfig"))
+	assert.False(t, pathExists("out/other"))
+	assert.True(t, isSymlink("out/zshrc"))
+	assert.True(t, st.owns("out/zshrc"))
+}
+
+func TestApplyReportsActions(t *testing.T) {
+	to := "out/"
+	assert.Nil(t, createPath(to))
+	defer func() {
+		_ = os.RemoveAll(to)
+	}()
+
+	d := Dots{
+		FileMappings: []FileMapping{
+			{From: "examples/zshrc", To: "out/zshrc", As: "link"},
+			{From: "examples/nonexistent", To: "out/other", As: "copy"},
+		},
+	}
+	r := d.Apply(context.Background(), newTestState(t), Options{})
+	assert.Equal(t, 2, len(r.Actions))
+	assert.Equal(t, ResultRolledBack, r.Actions[0].Result)
+	assert.Equal(t, "link", r.Actions[0].As())
+	assert.Equal(t, ResultFailed, r.Actions[1].Result)
+	assert.NotNil(t, r.Actions[1].Err)
+
+	// nothing is done once cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r = d.Apply(ctx, newTestState(t), Options{KeepGoing: true})
+	assert.Equal(t, 2, len(r.Failures))
+	assert.Equal(t, context.Canceled, r.Failures[0].Err)
+	assert.False(t, pathExists("out/zshrc"))
+}
+
+func TestReportString(t *testing.T) {
+	failed := []Failure{{Entry: "zshrc", Err: errors.New("failed")}}
+	assert.Equal(t, "2 applied, 1 unchanged, 1 failed", Report{Applied: 2, Unchanged: 1, Failures: failed}.String())
+	assert.Equal(t, "0 applied, 0 unchanged, 1 failed; rolled back all changes", Report{Failures: failed, RolledBack: true}.String())
+}
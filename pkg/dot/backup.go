@@ -0,0 +1,204 @@
+package dot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+)
+
+/*
+ * backups: targets dot did not create are moved aside instead of removed
+ */
+
+const backupIndexFile = "index.json"
+
+const backupStampFormat = "20060102T150405"
+
+type backupEntry struct {
+	Target string `json:"target"`
+	Backup string `json:"backup"`
+}
+
+func stateDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); len(dir) > 0 {
+		return filepath.Join(dir, "dot")
+	}
+	return filepath.Join(getHomeDir(), ".local", "state", "dot")
+}
+
+func (opts Opts) backupRoot() string {
+	if len(opts.Backup) > 0 {
+		return opts.Backup
+	}
+	return filepath.Join(stateDir(), "backups")
+}
+
+// newBackupStamp names the directory the backups a run takes go into: the
+// time it started, or the next second no earlier run took in root
+func newBackupStamp(root string) string {
+	now := time.Now()
+	for {
+		stamp := now.Format(backupStampFormat)
+		if !pathExists(filepath.Join(root, stamp)) {
+			return stamp
+		}
+		now = now.Add(time.Second)
+	}
+}
+
+// backupDir is where the run backs targets up to; all backups taken during
+// a run go into the same timestamped directory
+func (opts Opts) backupDir() string {
+	root := opts.backupRoot()
+	if len(opts.stamp) == 0 {
+		return filepath.Join(root, newBackupStamp(root))
+	}
+	return filepath.Join(root, opts.stamp)
+}
+
+func absPath(p string) string {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+	return abs
+}
+
+func backupPath(dir, target string) string {
+	return filepath.Join(dir, absPath(target))
+}
+
+func readBackupIndex(dir string) ([]backupEntry, error) {
+	var entries []backupEntry
+	data, err := os.ReadFile(filepath.Join(dir, backupIndexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %v", filepath.Join(dir, backupIndexFile), err)
+	}
+	return entries, nil
+}
+
+func writeBackupIndex(dir string, entries []backupEntry) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, backupIndexFile), data, 0600)
+}
+
+// movePath renames from to to, or copies and then removes it when they are
+// on different filesystems
+func movePath(from, to string) error {
+	err := os.Rename(from, to)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if err := cloneTree(from, to); err != nil {
+		_ = os.RemoveAll(to)
+		return err
+	}
+	return os.RemoveAll(from)
+}
+
+func backupTarget(dir, target, backup string) error {
+	if err := createPath(backup); err != nil {
+		return err
+	}
+	if err := movePath(target, backup); err != nil {
+		return err
+	}
+	entries, err := readBackupIndex(dir)
+	if err != nil {
+		return err
+	}
+	return writeBackupIndex(dir, append(entries, backupEntry{Target: absPath(target), Backup: backup}))
+}
+
+func latestBackup(root string) (string, error) {
+	dirEntries, err := os.ReadDir(root)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	var stamps []string
+	for _, entry := range dirEntries {
+		if entry.IsDir() {
+			stamps = append(stamps, entry.Name())
+		}
+	}
+	if len(stamps) == 0 {
+		return "", fmt.Errorf("no backups found in %s", root)
+	}
+	sort.Strings(stamps)
+	return stamps[len(stamps)-1], nil
+}
+
+// restoreBackups puts back every target backed up in the given run (the
+// latest one if empty), replacing whatever dot put in their place; what is
+// there that dot did not put there is backed up in turn, into a run of
+// its own
+func restoreBackups(root, stamp string, st *State, o Options) error {
+	if len(stamp) == 0 {
+		latest, err := latestBackup(root)
+		if err != nil {
+			return err
+		}
+		stamp = latest
+	}
+	dir := filepath.Join(root, stamp)
+	if !pathExists(dir) {
+		return fmt.Errorf("backup %s not found in %s", stamp, root)
+	}
+
+	entries, err := readBackupIndex(dir)
+	if err != nil {
+		return err
+	}
+	var aside string
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if pathExists(entry.Target) && !st.owns(entry.Target) {
+			if len(aside) == 0 {
+				aside = filepath.Join(root, newBackupStamp(root))
+			}
+			backup := backupPath(aside, entry.Target)
+			if err := backupTarget(aside, entry.Target, backup); err != nil {
+				return fmt.Errorf("failed backing up %s: %v", entry.Target, err)
+			}
+			o.logf("backed up %s to %s\n", entry.Target, backup)
+		}
+		if err := os.RemoveAll(entry.Target); err != nil {
+			return err
+		}
+		if err := createPath(entry.Target); err != nil {
+			return err
+		}
+		if err := movePath(entry.Backup, entry.Target); err != nil {
+			return err
+		}
+		if err := writeBackupIndex(dir, entries[:i]); err != nil {
+			return err
+		}
+		if err := st.forget(entry.Target); err != nil {
+			return err
+		}
+		o.logf("restored %s\n", entry.Target)
+	}
+
+	return os.RemoveAll(dir)
+}
+
+// Restore puts back every target backed up in the given run of dot (the
+// latest one if empty)
+func (dots Dots) Restore(stamp string, st *State, o Options) error {
+	return restoreBackups(dots.Opts.backupRoot(), stamp, st, o)
+}
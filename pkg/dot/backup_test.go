@@ -0,0 +1,209 @@
+package dot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupRoot(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", "/state")
+	assert.Equal(t, "/state/dot/backups", Opts{}.backupRoot())
+	assert.Equal(t, "/some/dir", Opts{Backup: "/some/dir"}.backupRoot())
+
+	t.Setenv("XDG_STATE_HOME", "")
+	assert.Equal(t, os.Getenv("HOME")+"/.local/state/dot/backups", Opts{}.backupRoot())
+}
+
+func TestPlanBacksUpUnmanagedTargets(t *testing.T) {
+	to := "out/"
+	assert.Nil(t, createPath(to))
+	defer func() {
+		_ = os.RemoveAll(to)
+	}()
+	root := t.TempDir()
+	opts := Opts{Backup: root}
+	st := newTestState(t)
+
+	// foreign file
+	assert.Nil(t, os.WriteFile("out/zshrc", []byte("mine"), 0644))
+	m := FileMapping{From: "examples/zshrc", To: "out/zshrc", As: "link"}
+	actions := m.plan(opts, st, Options{})
+	assert.Equal(t, []string{ActionBackup, ActionLink}, kinds(actions))
+	assert.Equal(t, "out/zshrc", actions[0].From)
+	assert.Equal(t, root, filepath.Dir(actions[0].backupDir))
+	assert.Equal(t, backupPath(actions[0].backupDir, "out/zshrc"), actions[0].To)
+
+	// copy created by dot, whose source changed since
+	m = FileMapping{From: "examples/gitconfig", To: "out/gitconfig", As: "copy"}
+	assert.Nil(t, m.doCopy())
+	assert.Nil(t, st.record(m.To, StateEntry{Source: m.From, As: m.As}))
+	m.From = "examples/zshrc"
+	assert.Equal(t, []string{ActionRemove, ActionCopy}, kinds(m.plan(opts, st, Options{})))
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	to := "out/"
+	assert.Nil(t, createPath(to))
+	defer func() {
+		_ = os.RemoveAll(to)
+	}()
+	root := t.TempDir()
+
+	assert.Nil(t, os.WriteFile("out/zshrc", []byte("mine"), 0644))
+
+	dir := Opts{Backup: root}.backupDir()
+	backup := backupPath(dir, "out/zshrc")
+	assert.Nil(t, backupTarget(dir, "out/zshrc", backup))
+	assert.False(t, pathExists("out/zshrc"))
+	content, err := os.ReadFile(backup)
+	assert.Nil(t, err)
+	assert.Equal(t, "mine", string(content))
+
+	entries, err := readBackupIndex(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, []backupEntry{{Target: absPath("out/zshrc"), Backup: backup}}, entries)
+
+	// dot maps its own file in place
+	m := FileMapping{From: "examples/zshrc", To: "out/zshrc", As: "link"}
+	assert.Nil(t, m.doLink())
+	st := newTestState(t)
+	assert.Nil(t, st.record(m.To, StateEntry{Source: m.From, As: m.As}))
+
+	assert.Nil(t, restoreBackups(root, "", st, Options{}))
+	assert.False(t, isSymlink("out/zshrc"))
+	content, err = os.ReadFile("out/zshrc")
+	assert.Nil(t, err)
+	assert.Equal(t, "mine", string(content))
+	assert.False(t, pathExists(dir))
+	assert.Empty(t, st.Targets)
+
+	// nothing left to restore
+	assert.NotNil(t, restoreBackups(root, "", st, Options{}))
+	assert.NotNil(t, restoreBackups(root, filepath.Base(dir), st, Options{}))
+}
+
+func TestRestoreKeepsLocalEdits(t *testing.T) {
+	to := "out/"
+	assert.Nil(t, createPath(to))
+	defer func() {
+		_ = os.RemoveAll(to)
+	}()
+	root := t.TempDir()
+	d := Dots{
+		Opts:         Opts{Backup: root},
+		FileMappings: []FileMapping{{From: "examples/zshrc", To: "out/zshrc", As: "link"}},
+	}
+	st := newTestState(t)
+	assert.Nil(t, os.WriteFile("out/zshrc", []byte("mine"), 0644))
+	assert.Empty(t, d.Apply(context.Background(), st, Options{}).Failures)
+	stamps, err := os.ReadDir(root)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(stamps))
+
+	// edited in place of dot's link since
+	assert.Nil(t, os.Remove("out/zshrc"))
+	assert.Nil(t, os.WriteFile("out/zshrc", []byte("edited"), 0644))
+
+	assert.Nil(t, d.Restore("", st, Options{}))
+	assert.Equal(t, "mine", readString(t, "out/zshrc"))
+
+	// the edits went into a backup of their own, which restores in turn
+	stamps, err = os.ReadDir(root)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(stamps))
+	aside := filepath.Join(root, stamps[0].Name())
+	assert.Equal(t, "edited", readString(t, backupPath(aside, "out/zshrc")))
+	assert.Nil(t, d.Restore("", st, Options{}))
+	assert.Equal(t, "edited", readString(t, "out/zshrc"))
+}
+
+func TestEachApplyBacksUpSeparately(t *testing.T) {
+	to := "out/"
+	assert.Nil(t, createPath(to))
+	defer func() {
+		_ = os.RemoveAll(to)
+	}()
+	root := t.TempDir()
+	d := Dots{
+		Opts:         Opts{Backup: root},
+		FileMappings: []FileMapping{{From: "examples/zshrc", To: "out/zshrc", As: "link"}},
+	}
+	st := newTestState(t)
+
+	// within the same second, as a program embedding dot could
+	for _, content := range []string{"first", "second"} {
+		assert.Nil(t, os.RemoveAll("out/zshrc"))
+		assert.Nil(t, os.WriteFile("out/zshrc", []byte(content), 0644))
+		assert.Empty(t, d.Apply(context.Background(), st, Options{}).Failures)
+	}
+
+	stamps, err := os.ReadDir(root)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(stamps))
+	for i, content := range []string{"first", "second"} {
+		dir := filepath.Join(root, stamps[i].Name())
+		entries, err := readBackupIndex(dir)
+		assert.Nil(t, err)
+		assert.Equal(t, []backupEntry{{Target: absPath("out/zshrc"), Backup: backupPath(dir, "out/zshrc")}}, entries)
+		assert.Equal(t, content, readString(t, backupPath(dir, "out/zshrc")))
+	}
+}
+
+// otherFilesystem is a directory on another filesystem than the tests',
+// if there is one
+func otherFilesystem(t *testing.T) string {
+	dir, err := os.MkdirTemp("/dev/shm", "dot-test")
+	if err != nil {
+		t.Skip("no /dev/shm to back up to")
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	if same, ok := sameFilesystem(dir, t.TempDir()); !ok || same {
+		t.Skip("/dev/shm is on the same filesystem")
+	}
+	return dir
+}
+
+func TestBackupAcrossFilesystems(t *testing.T) {
+	root := otherFilesystem(t)
+	to := "out/"
+	assert.Nil(t, createPath(to))
+	defer func() {
+		_ = os.RemoveAll(to)
+	}()
+	assert.Nil(t, os.MkdirAll("out/app/sub", 0750))
+	assert.Nil(t, os.WriteFile("out/app/sub/prefs", []byte("mine"), 0600))
+	assert.Nil(t, os.Symlink("sub/prefs", "out/app/link"))
+	assert.Nil(t, os.WriteFile("out/zshrc", []byte("mine too"), 0644))
+	st := newTestState(t)
+
+	// rolled back from the other filesystem
+	d := Dots{
+		Opts: Opts{Backup: root},
+		FileMappings: []FileMapping{
+			{From: "examples/zshrc", To: "out/app", As: "link"},
+			{From: "examples/nonexistent", To: "out/zshrc", As: "copy"},
+		},
+	}
+	r := d.Apply(context.Background(), st, Options{})
+	assert.True(t, r.RolledBack)
+	assert.Equal(t, "mine", readString(t, "out/app/sub/prefs"))
+	assert.Equal(t, os.FileMode(0600), fileMode(t, "out/app/sub/prefs"))
+	assert.Equal(t, "mine too", readString(t, "out/zshrc"))
+
+	// backed up to, and restored from, the other filesystem
+	d.FileMappings = d.FileMappings[:1]
+	r = d.Apply(context.Background(), st, Options{})
+	assert.Empty(t, r.Failures)
+	assert.True(t, isSymlink("out/app"))
+	assert.Nil(t, d.Restore("", st, Options{}))
+	assert.Equal(t, "mine", readString(t, "out/app/sub/prefs"))
+	link, err := os.Readlink("out/app/link")
+	assert.Nil(t, err)
+	assert.Equal(t, "sub/prefs", link)
+}
@@ -0,0 +1,97 @@
+package dot
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+/*
+ * conflicts: what to do with destinations dot did not create
+ */
+
+const (
+	ConflictOverwrite = "overwrite"
+	ConflictBackup    = "backup"
+	ConflictSkip      = "skip"
+	ConflictFail      = "fail"
+	ConflictAsk       = "ask"
+)
+
+func isConflictPolicy(policy string) bool {
+	switch policy {
+	case "", ConflictOverwrite, ConflictBackup, ConflictSkip, ConflictFail, ConflictAsk:
+		return true
+	}
+	return false
+}
+
+// conflictPolicy resolves a mapping's or resource's policy, falling back to
+// the `opt` default and then to backing up
+func conflictPolicy(policy string, opts Opts) string {
+	if len(policy) > 0 {
+		return policy
+	}
+	if len(opts.Conflict) > 0 {
+		return opts.Conflict
+	}
+	return ConflictBackup
+}
+
+var stdin = bufio.NewReader(os.Stdin)
+
+func isTerminal(f *os.File) bool {
+	fileInfo, err := f.Stat()
+	return err == nil && fileInfo.Mode()&os.ModeCharDevice != 0
+}
+
+func askConflict(in *bufio.Reader, out io.Writer, target string) string {
+	for {
+		fmt.Fprintf(out, "%s already exists: [o]verwrite, [b]ackup, [s]kip or [f]ail? ", target)
+		line, err := in.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "o", ConflictOverwrite:
+			return ConflictOverwrite
+		case "b", ConflictBackup:
+			return ConflictBackup
+		case "s", ConflictSkip:
+			return ConflictSkip
+		case "f", ConflictFail:
+			return ConflictFail
+		}
+		if err != nil {
+			return ConflictFail
+		}
+	}
+}
+
+func promptConflict(target string, o Options) string {
+	if !isTerminal(os.Stdin) {
+		o.logf("cannot ask about %s, not running in a terminal; skipping\n", target)
+		return ConflictSkip
+	}
+	return askConflict(stdin, os.Stderr, target)
+}
+
+// resolveConflicts replaces every `ask` action with the actions for the
+// policy chosen; all questions are asked before anything is changed. An
+// answer that is not a policy to apply fails the entry
+func resolveConflicts(actions []Action, ask func(target string) string) []Action {
+	var resolved []Action
+	for _, action := range actions {
+		if action.Kind != ActionAsk {
+			resolved = append(resolved, action)
+			continue
+		}
+		switch policy := ask(action.To); policy {
+		case ConflictOverwrite, ConflictBackup, ConflictSkip, ConflictFail:
+			resolved = append(resolved, conflictActions(action.To, policy, action.backupDir, *action.next)...)
+		default:
+			reason := fmt.Sprintf("destination exists, and %q is not overwrite, backup, skip or fail", policy)
+			resolved = append(resolved, Action{Kind: ActionFail, From: action.From, To: action.To, Reason: reason})
+		}
+	}
+	return resolved
+}
@@ -0,0 +1,139 @@
+package dot
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConflictPolicy(t *testing.T) {
+	assert.Equal(t, ConflictBackup, conflictPolicy("", Opts{}))
+	assert.Equal(t, ConflictSkip, conflictPolicy("", Opts{Conflict: ConflictSkip}))
+	assert.Equal(t, ConflictFail, conflictPolicy(ConflictFail, Opts{Conflict: ConflictSkip}))
+}
+
+func TestPlanConflicts(t *testing.T) {
+	to := "out/"
+	assert.Nil(t, createPath(to))
+	defer func() {
+		_ = os.RemoveAll(to)
+	}()
+	assert.Nil(t, os.WriteFile("out/zshrc", []byte("mine"), 0644))
+
+	st := newTestState(t)
+	opts := Opts{Backup: t.TempDir()}
+	cases := map[string][]string{
+		"":                {ActionBackup, ActionLink},
+		ConflictBackup:    {ActionBackup, ActionLink},
+		ConflictOverwrite: {ActionOverwrite, ActionLink},
+		ConflictSkip:      {ActionSkip},
+		ConflictFail:      {ActionFail},
+		ConflictAsk:       {ActionAsk},
+	}
+	for policy, want := range cases {
+		m := FileMapping{From: "examples/zshrc", To: "out/zshrc", As: "link", Conflict: policy}
+		assert.Equal(t, want, kinds(m.plan(opts, st, Options{})), policy)
+	}
+
+	// opt default
+	m := FileMapping{From: "examples/zshrc", To: "out/zshrc", As: "link"}
+	assert.Equal(t, []string{ActionSkip}, kinds(m.plan(Opts{Conflict: ConflictSkip}, st, Options{})))
+
+	// resources too
+	r := Resource{Url: "https://example.com/zshrc", To: "out/zshrc", As: "file", Conflict: ConflictSkip}
+	assert.Equal(t, []string{ActionSkip}, kinds(r.plan(opts, st, Options{})))
+
+	// policies only apply to destinations dot did not create
+	assert.Nil(t, os.Remove("out/zshrc"))
+	m = FileMapping{From: "examples/gitconfig", To: "out/zshrc", As: "copy", Conflict: ConflictFail}
+	assert.Nil(t, m.doCopy())
+	assert.Nil(t, st.record(m.To, StateEntry{Source: m.From, As: m.As}))
+	m.From = "examples/zshrc"
+	assert.Equal(t, []string{ActionRemove, ActionCopy}, kinds(m.plan(opts, st, Options{})))
+}
+
+func TestOverwrite(t *testing.T) {
+	to := "out/"
+	assert.Nil(t, createPath(to))
+	defer func() {
+		_ = os.RemoveAll(to)
+	}()
+	assert.Nil(t, os.MkdirAll("out/zsh/plugins", 0750))
+
+	st := newTestState(t)
+	m := FileMapping{From: "examples/zshrc", To: "out/zsh", As: "link", Conflict: ConflictOverwrite}
+	d := Dots{FileMappings: []FileMapping{m}}
+	assert.Empty(t, d.Apply(context.Background(), st, Options{}).Failures)
+	assert.True(t, isSymlink("out/zsh"))
+}
+
+func TestAskConflict(t *testing.T) {
+	cases := map[string]string{
+		"o\n":             ConflictOverwrite,
+		"backup\n":        ConflictBackup,
+		"x\nS\n":          ConflictSkip,
+		"f\n":             ConflictFail,
+		"not an answer\n": ConflictFail,
+	}
+	for in, want := range cases {
+		var out bytes.Buffer
+		got := askConflict(bufio.NewReader(strings.NewReader(in)), &out, "~/.zshrc")
+		assert.Equal(t, want, got, in)
+		assert.Contains(t, out.String(), "~/.zshrc already exists")
+	}
+}
+
+func TestResolveConflicts(t *testing.T) {
+	next := Action{Kind: ActionLink, From: "examples/zshrc", To: "out/zshrc"}
+	actions := []Action{
+		{Kind: ActionAsk, From: next.From, To: next.To, next: &next, backupDir: "/backups"},
+		{Kind: ActionUnchanged, To: "out/other"},
+	}
+
+	cases := map[string][]string{
+		ConflictOverwrite: {ActionOverwrite, ActionLink, ActionUnchanged},
+		ConflictBackup:    {ActionBackup, ActionLink, ActionUnchanged},
+		ConflictSkip:      {ActionSkip, ActionUnchanged},
+		ConflictFail:      {ActionFail, ActionUnchanged},
+	}
+	for policy, want := range cases {
+		var asked []string
+		resolved := resolveConflicts(actions, func(target string) string {
+			asked = append(asked, target)
+			return policy
+		})
+		assert.Equal(t, want, kinds(resolved), policy)
+		assert.Equal(t, []string{"out/zshrc"}, asked)
+	}
+
+	// anything else fails rather than leaving the entry undone
+	for _, answer := range []string{ConflictAsk, "", "yes"} {
+		resolved := resolveConflicts(actions, func(string) string {
+			return answer
+		})
+		assert.Equal(t, []string{ActionFail, ActionUnchanged}, kinds(resolved), answer)
+		assert.Equal(t, fmt.Sprintf("fail out/zshrc: destination exists, and %q is not overwrite, backup, skip or fail", answer), resolved[0].String())
+	}
+}
+
+func TestValidateConflict(t *testing.T) {
+	d := Dots{
+		Opts: Opts{Conflict: "sometimes"},
+		FileMappings: []FileMapping{
+			{From: "examples/zshrc", Conflict: "never"},
+		},
+		Resources: []Resource{
+			{Url: "http://example.com", To: "out/", As: "file", Conflict: ConflictSkip},
+		},
+	}
+	errs := d.Validate()
+	assert.Equal(t, 2, len(errs))
+	assert.Contains(t, errs, fmt.Errorf("%s: unknown conflict policy `%s`", "examples/zshrc", "never"))
+	assert.Contains(t, errs, fmt.Errorf("opt: unknown conflict policy `%s`", "sometimes"))
+}
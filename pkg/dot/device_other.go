@@ -0,0 +1,8 @@
+//go:build !unix
+
+package dot
+
+// device is not known off unix; hard links are then left to fail when made
+func device(path string) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package dot
+
+import "syscall"
+
+// device is the ID of the device holding path
+func device(path string) (uint64, bool) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return 0, false
+	}
+	return uint64(st.Dev), true
+}
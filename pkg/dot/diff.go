@@ -0,0 +1,133 @@
+package dot
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+/*
+ * diff: what applying would change in mapped files' contents
+ */
+
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func describeTarget(path string) string {
+	if !pathExists(path) {
+		return "missing"
+	}
+	if dst, err := os.Readlink(path); err == nil {
+		return "symlink to " + dst
+	}
+	if isDirectory(path) {
+		return "directory"
+	}
+	return "file"
+}
+
+// diff returns a unified diff between the mapping's target and what
+// mapping it would produce, or "" if they are the same
+func (m FileMapping) diff(st *State) (string, error) {
+	if !m.isMatchingOs() || m.isUpToDate(st) {
+		return "", nil
+	}
+
+	switch m.As {
+	case "link":
+		target, err := m.linkTarget()
+		if err != nil {
+			return "", err
+		}
+		var diff string
+		if !m.isLinked() {
+			diff = fmt.Sprintf("--- %s (%s)\n+++ %s (symlink to %s)\n", m.To, describeTarget(m.To), m.To, target)
+		}
+		if m.isRenderedLink() {
+			rendered, err := m.rendering().diff(st)
+			if err != nil {
+				return "", err
+			}
+			diff += rendered
+		}
+		return diff, nil
+	case "hardlink":
+		return fmt.Sprintf("--- %s (%s)\n+++ %s (hard link to %s)\n", m.To, describeTarget(m.To), m.To, m.From), nil
+	case "copy":
+		if isDirectory(m.From) {
+			return m.treeDiff()
+		}
+		want, err := m.content()
+		if err != nil {
+			return "", err
+		}
+		return copyDiff(m.To, m.From, want)
+	case "tree":
+		unlinked, err := m.unlinkedFiles()
+		if err != nil {
+			return "", err
+		}
+		var diff strings.Builder
+		for _, rel := range unlinked {
+			link := m.fileLink(rel)
+			target, err := link.linkTarget()
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&diff, "--- %s (%s)\n+++ %s (symlink to %s)\n", link.To, describeTarget(link.To), link.To, target)
+		}
+		return diff.String(), nil
+	}
+	return "", nil
+}
+
+// copyDiff returns a unified diff between the file at to and what copying
+// from over it would write, want
+func copyDiff(to, from string, want []byte) (string, error) {
+	var got []byte
+	var err error
+	fromFile := to
+	if desc := describeTarget(to); desc != "file" {
+		fromFile += " (" + desc + ")"
+	} else if got, err = os.ReadFile(to); err != nil {
+		return "", err
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(string(got)),
+		B:        splitLines(string(want)),
+		FromFile: fromFile,
+		ToFile:   to + " (from " + from + ")",
+		Context:  3,
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(diff) == 0 {
+		// same contents, different mode
+		diff = fmt.Sprintf("--- %s\n+++ %s (mode changed)\n", to, to)
+	}
+	return diff, nil
+}
+
+// Diff returns a unified diff per mapping applying the dots file would
+// change, along with the mappings it failed to diff
+func (dots Dots) Diff(st *State) ([]string, []error) {
+	var diffs []string
+	var errs []error
+	for _, mapping := range dots.FileMappings {
+		diff, err := mapping.diff(st)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", mapping.From, err))
+		} else if len(diff) > 0 {
+			diffs = append(diffs, diff)
+		}
+	}
+	return diffs, errs
+}
@@ -0,0 +1,65 @@
+package dot
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	to := "out/"
+	assert.Nil(t, createPath(to))
+	defer func() {
+		_ = os.RemoveAll(to)
+	}()
+	st := newTestState(t)
+
+	// templated copy against an outdated target
+	m := FileMapping{
+		From: "fixtures/gpg-agent.conf.input",
+		To:   "out/gpg-agent.conf",
+		As:   "copy",
+		With: map[string]string{"PinentryPath": "/opt/homebrew/bin"},
+	}
+	assert.Nil(t, os.WriteFile(m.To, []byte(readString(t, "fixtures/gpg-agent.conf.output")), 0644))
+	diff, err := m.diff(st)
+	assert.Nil(t, err)
+	assert.Equal(t, `--- out/gpg-agent.conf
++++ out/gpg-agent.conf (from fixtures/gpg-agent.conf.input)
+@@ -1,4 +1,4 @@
+ default-cache-ttl 1800
+ max-cache-ttl 3600
+ enable-ssh-support
+-pinentry-program /foo/bar/pinentry-tty
++pinentry-program /opt/homebrew/bin/pinentry-tty
+`, diff)
+
+	// missing target
+	m = FileMapping{From: "examples/zshrc", To: "out/zshrc", As: "copy"}
+	diff, err = m.diff(st)
+	assert.Nil(t, err)
+	assert.Contains(t, diff, "--- out/zshrc (missing)\n")
+
+	// up to date
+	assert.Nil(t, m.doCopy())
+	diff, err = m.diff(st)
+	assert.Nil(t, err)
+	assert.Equal(t, "", diff)
+
+	// links
+	m = FileMapping{From: "examples/gitconfig", To: "out/gitconfig", As: "link"}
+	diff, err = m.diff(st)
+	assert.Nil(t, err)
+	assert.Equal(t, "--- out/gitconfig (missing)\n+++ out/gitconfig (symlink to examples/gitconfig)\n", diff)
+
+	assert.Nil(t, os.Symlink("examples/zshrc", m.To))
+	diff, err = m.diff(st)
+	assert.Nil(t, err)
+	assert.Equal(t, "--- out/gitconfig (symlink to examples/zshrc)\n+++ out/gitconfig (symlink to examples/gitconfig)\n", diff)
+
+	d := Dots{FileMappings: []FileMapping{m, {From: "examples/nonexistent", To: "out/foo", As: "copy"}}}
+	diffs, errs := d.Diff(st)
+	assert.Equal(t, 1, len(diffs))
+	assert.Equal(t, 1, len(errs))
+}
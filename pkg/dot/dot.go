@@ -0,0 +1,772 @@
+// Package dot maps dot files to where they belong and fetches the resources
+// they depend on, as described by a dots file
+package dot
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"gopkg.in/yaml.v3"
+	"text/template"
+)
+
+/*
+ * core data structures and operations
+ */
+
+type FileMapping struct {
+	From     string
+	To       string
+	As       string
+	Os       string
+	With     map[string]string
+	Conflict string
+	Hooks    Hooks
+	Ignore   []string
+	Mode     Mode
+	DirMode  Mode `yaml:"dir_mode"`
+	Relative bool
+	Render   bool
+
+	ignore *ignorer
+	tmpl   *templater
+}
+
+func (m FileMapping) doLink() error {
+	target, err := m.linkTarget()
+	if err != nil {
+		return err
+	}
+	err = os.Symlink(target, m.To)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m FileMapping) doCopy() error {
+	if isDirectory(m.From) {
+		return m.copyTree()
+	}
+
+	var inReader io.Reader
+	if m.renders() {
+		in, err := m.content()
+		if err != nil {
+			return err
+		}
+		inReader = bytes.NewReader(in)
+	} else {
+		fin, err := os.Open(m.From)
+		if err != nil {
+			return err
+		}
+		defer fin.Close()
+		inReader = fin
+	}
+
+	mode, err := m.fileMode()
+	if err != nil {
+		return err
+	}
+	fout, err := os.OpenFile(m.To, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer fout.Close()
+
+	_, err = io.Copy(fout, inReader)
+	if err != nil {
+		return err
+	}
+
+	// not subject to the umask, nor left as it was when overwriting
+	return fout.Chmod(mode)
+}
+
+// domap maps the file unless its target is already in the desired state,
+// reporting whether anything changed
+func (m FileMapping) domap(st *State, o Options) (bool, error) {
+	if m.isUpToDate(st) {
+		o.debugf("unchanged %s -> %s\n", m.From, m.To)
+		return false, nil
+	}
+
+	// ensure destination path exists
+	if err := createPathMode(m.To, m.DirMode.orDefault(defaultDirMode)); err != nil {
+		return false, fmt.Errorf("failed creating path %s, %v", m.To, err)
+	}
+
+	var err error
+	switch typ := m.As; typ {
+	case "link":
+		if m.isRenderedLink() {
+			err = m.render()
+		}
+		// the link may be in place already, with only the rendering stale
+		if err == nil && !m.isLinked() {
+			err = m.doLink()
+		}
+	case "copy":
+		err = m.doCopy()
+	case "hardlink":
+		err = m.doHardlink()
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed %s %s -> %s: %v", m.As+"ing", m.From, m.To, err)
+	}
+	o.debugf("%s %s -> %s\n", m.As+"ing", m.From, m.To)
+	return true, nil
+}
+
+func (m FileMapping) content() ([]byte, error) {
+	in, err := os.ReadFile(m.From)
+	if err != nil {
+		return nil, err
+	}
+	if m.renders() {
+		out, err := m.tmpl.render(string(in), m.With)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", m.From, err)
+		}
+		return []byte(out), nil
+	}
+	return in, nil
+}
+
+// isUpToDate reports whether the target is exactly what mapping the file
+// would produce: a link to the source, or a copy with the same contents
+// (and the same mode dot created it with)
+func (m FileMapping) isUpToDate(st *State) bool {
+	switch m.As {
+	case "link":
+		return m.isLinked() && (!m.isRenderedLink() || m.rendering().isUpToDate(st))
+	case "hardlink":
+		return isSameFile(m.To, m.From)
+	case "copy":
+		if isDirectory(m.From) {
+			return m.isTreeUpToDate()
+		}
+		if isSymlink(m.To) || isDirectory(m.To) {
+			return false
+		}
+		want, err := m.content()
+		if err != nil {
+			return false
+		}
+		got, err := os.ReadFile(m.To)
+		if err != nil || !bytes.Equal(want, got) {
+			return false
+		}
+		mode, err := m.fileMode()
+		if err != nil {
+			return false
+		}
+		fileInfo, err := os.Stat(m.To)
+		return err == nil && fileInfo.Mode().Perm() == mode
+	case "tree":
+		return m.isTreeLinked()
+	}
+	return false
+}
+
+// isManaged reports whether the mapping's target is what dot itself would
+// have put there, in which case it is safe to remove
+func (m FileMapping) isManaged(st *State) bool {
+	return st.owns(m.To) || m.isUpToDate(st)
+}
+
+func (m FileMapping) isMatchingOs() bool {
+	return matchesOs(m.Os)
+}
+
+// matchesOs reports whether an `os` attribute includes the running OS
+func matchesOs(name string) bool {
+	osMap := map[string]string{
+		"linux":  "linux",
+		"macos":  "darwin",
+		"darwin": "darwin",
+		"all":    runtime.GOOS,
+		"":       runtime.GOOS,
+	}
+	return osMap[name] == runtime.GOOS
+}
+
+type Opts struct {
+	Cd       string
+	Backup   string
+	Conflict string
+	Hooks    Hooks
+	Relative bool
+
+	// the run's backup stamp, once it started
+	stamp string
+}
+
+type Dots struct {
+	Opts         Opts          `yaml:"opt"`
+	FileMappings []FileMapping `yaml:"map"`
+	Resources    []Resource    `yaml:"fetch"`
+	Scripts      []Script      `yaml:"run"`
+
+	// the variables every template sees; once transformed, Vars holds them
+	// all, merged
+	Vars      map[string]interface{}            `yaml:"vars"`
+	VarsFiles []string                          `yaml:"vars_files"`
+	VarsOs    map[string]map[string]interface{} `yaml:"vars_os"`
+	VarsHost  map[string]map[string]interface{} `yaml:"vars_host"`
+	// Set holds variables that take precedence over the dots file's own,
+	// say from the command line
+	Set map[string]interface{} `yaml:"-"`
+
+	// Warnings lists what is suspicious, but not wrong, about the dots file
+	Warnings []string `yaml:"-"`
+	// File is the absolute path of the dots file read, which owns what
+	// applying it creates
+	File string `yaml:"-"`
+}
+
+type YamlURL struct {
+	*url.URL
+}
+
+type Resource struct {
+	Url      string `yaml:"url"`
+	To       string `yaml:"to"`
+	As       string `yaml:"as"`
+	Skip     bool   `yaml:"skip"`
+	Conflict string `yaml:"conflict"`
+	Hooks    Hooks  `yaml:"hooks"`
+	Mode     Mode   `yaml:"mode"`
+	DirMode  Mode   `yaml:"dir_mode"`
+}
+
+func (d *Dots) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var tmpDots struct {
+		Opts      Opts                              `yaml:"opt"`
+		Mappings  map[string]FileMapping            `yaml:"map"`
+		Resources []Resource                        `yaml:"fetch"`
+		Scripts   []Script                          `yaml:"run"`
+		Vars      map[string]interface{}            `yaml:"vars"`
+		VarsFiles []string                          `yaml:"vars_files"`
+		VarsOs    map[string]map[string]interface{} `yaml:"vars_os"`
+		VarsHost  map[string]map[string]interface{} `yaml:"vars_host"`
+	}
+	err := unmarshal(&tmpDots)
+	if err != nil {
+		return err
+	}
+	// decoding into a map loses the order files are listed in; keep it
+	// around so that actions are planned in document order
+	var order struct {
+		Mappings mappingOrder         `yaml:"map"`
+		Rest     map[string]yaml.Node `yaml:",inline"`
+	}
+	if err := unmarshal(&order); err != nil {
+		return err
+	}
+	d.Opts = tmpDots.Opts
+	for _, file := range order.Mappings {
+		mapping := tmpDots.Mappings[file]
+		mapping.From = file
+		d.FileMappings = append(d.FileMappings, mapping)
+	}
+	d.Resources = tmpDots.Resources
+	d.Scripts = tmpDots.Scripts
+	d.Vars = tmpDots.Vars
+	d.VarsFiles = tmpDots.VarsFiles
+	d.VarsOs = tmpDots.VarsOs
+	d.VarsHost = tmpDots.VarsHost
+	return nil
+}
+
+type mappingOrder []string
+
+func (o *mappingOrder) UnmarshalYAML(node *yaml.Node) error {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		*o = append(*o, node.Content[i].Value)
+	}
+	return nil
+}
+
+// ValidationError holds everything wrong with a dots file
+type ValidationError struct {
+	Errs []error
+}
+
+func (e ValidationError) Error() string {
+	var msgs []string
+	for _, err := range e.Errs {
+		msgs = append(msgs, "failed validating dots file: "+err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Validate checks a transformed dots file, returning everything wrong with it
+func (dots Dots) Validate() []error {
+	var errs []error
+	for _, mapping := range dots.FileMappings {
+		if !pathExists(mapping.From) {
+			errs = append(errs, fmt.Errorf("%s: path does not exist", mapping.From))
+		} else if !isDirectory(mapping.From) && mapping.As == "tree" {
+			errs = append(errs, fmt.Errorf("%s: tree type needs a directory", mapping.From))
+		}
+
+		if mapping.As != "copy" && mapping.As != "link" && mapping.renders() {
+			errs = append(errs, fmt.Errorf("%s: templating is only supported in `copy` and `link` modes", mapping.From))
+		}
+
+		if !isConflictPolicy(mapping.Conflict) {
+			errs = append(errs, fmt.Errorf("%s: unknown conflict policy `%s`", mapping.From, mapping.Conflict))
+		}
+
+		if mapping.As != "copy" && !mapping.isRenderedLink() && mapping.Mode != 0 {
+			errs = append(errs, fmt.Errorf("%s: `mode` is only supported in `copy` mode", mapping.From))
+		}
+
+		if mapping.As == "hardlink" && pathExists(mapping.From) {
+			if isDirectory(mapping.From) {
+				errs = append(errs, fmt.Errorf("%s: cannot hard link a directory", mapping.From))
+			} else if same, ok := sameFilesystem(mapping.From, mapping.To); ok && !same {
+				errs = append(errs, fmt.Errorf("%s: cannot hard link across filesystems, to %s", mapping.From, mapping.To))
+			}
+		}
+
+		if mapping.Relative {
+			if err := mapping.checkRelative(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", mapping.From, err))
+			}
+		}
+	}
+	for _, resource := range dots.Resources {
+		if len(resource.To) == 0 {
+			errs = append(errs, fmt.Errorf("%s: resource destination (`to`) cannot be empty", resource.Url))
+		}
+		if len(resource.As) == 0 {
+			errs = append(errs, fmt.Errorf("%s: resource type (`as`) cannot be empty", resource.Url))
+		}
+		if !isConflictPolicy(resource.Conflict) {
+			errs = append(errs, fmt.Errorf("%s: unknown conflict policy `%s`", resource.Url, resource.Conflict))
+		}
+		if resource.As != "file" && resource.Mode != 0 {
+			errs = append(errs, fmt.Errorf("%s: `mode` is only supported for `file` resources", resource.Url))
+		}
+	}
+	names := map[string]bool{}
+	for _, script := range dots.Scripts {
+		if len(script.Name) == 0 {
+			errs = append(errs, fmt.Errorf("run: script name (`name`) cannot be empty"))
+			continue
+		}
+		if names[script.Name] {
+			errs = append(errs, fmt.Errorf("%s: duplicate script name", script.Name))
+		}
+		names[script.Name] = true
+		if len(script.Command) == 0 {
+			errs = append(errs, fmt.Errorf("%s: script (`script`) cannot be empty", script.Name))
+		}
+		if !isRunWhen(script.When) {
+			errs = append(errs, fmt.Errorf("%s: unknown `when` value `%s`", script.Name, script.When))
+		}
+		if script.When == RunOnChange && len(script.Watch) == 0 {
+			errs = append(errs, fmt.Errorf("%s: onchange scripts need files to `watch`", script.Name))
+		}
+		for _, watched := range script.Watch {
+			if !pathExists(watched) {
+				errs = append(errs, fmt.Errorf("%s: %s: path does not exist", script.Name, watched))
+			}
+		}
+	}
+	if !isConflictPolicy(dots.Opts.Conflict) {
+		errs = append(errs, fmt.Errorf("opt: unknown conflict policy `%s`", dots.Opts.Conflict))
+	}
+	return errs
+}
+
+func inferDestination(file string) string {
+	if strings.HasPrefix(file, ".") {
+		return getHomeDir() + "/" + file
+	} else {
+		return getHomeDir() + "/." + file
+	}
+}
+
+func evalTemplateString(templStr string, env interface{}, root string) (string, error) {
+	templ, err := template.New("template").Funcs(templateFuncs(root)).Parse(templStr)
+	if err != nil {
+		// not the text itself: it may well hold secrets
+		return "", fmt.Errorf("failed parsing template, %v", err)
+	}
+	var templOut bytes.Buffer
+	err = templ.Execute(&templOut, env)
+	if err != nil {
+		return "", fmt.Errorf("failed executing template, %v", err)
+	}
+	return templOut.String(), nil
+}
+
+func evalTemplate(with map[string]string, tp *templater) (map[string]string, error) {
+	newMap := make(map[string]string, len(with))
+	for variable, templ := range with {
+		value, err := tp.render(templ, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", variable, err)
+		}
+		newMap[variable] = value
+	}
+	return newMap, nil
+}
+
+// Transform resolves the dots file as written into what is mapped: glob
+// keys expanded, sources under `opt.cd`, destinations inferred or with `~`
+// expanded, variables merged into `Vars` and `with` values evaluated
+func (dots Dots) Transform() (Dots, []error) {
+	opts := dots.Opts
+
+	newDots := Dots{File: dots.File}
+	var errs []error
+	if len(opts.Backup) > 0 {
+		opts.Backup = expandTilde(opts.Backup)
+	}
+	newDots.Opts = opts
+
+	vars, varsErrs := dots.resolveVars(opts)
+	errs = append(errs, varsErrs...)
+	newDots.Vars = vars
+	tp := opts.newTemplater(vars)
+
+	patterns, err := opts.ignorePatterns()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("%s: %v", ignoreFile, err))
+	}
+
+	var mappings []FileMapping
+	for _, mapping := range dots.FileMappings {
+		expanded, err := opts.expandGlobs(mapping, patterns)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", mapping.From, err))
+			continue
+		}
+		if len(expanded) == 0 {
+			newDots.Warnings = append(newDots.Warnings, fmt.Sprintf("%s: glob matches nothing", mapping.From))
+		}
+		mappings = append(mappings, expanded...)
+	}
+
+	for _, mapping := range mappings {
+		// To is expanded / inferred first: it's value is based off of
+		// `from` before prefix or cwd are added to it
+		if len(mapping.To) > 0 {
+			// expand destination ~
+			mapping.To = expandTilde(mapping.To)
+		} else {
+			// infer destination based on From
+			mapping.To = inferDestination(mapping.From)
+		}
+
+		if len(mapping.With) > 0 {
+			with, err := evalTemplate(mapping.With, tp)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", mapping.From, err))
+			}
+			mapping.With = with
+		}
+		if mapping.renders() {
+			mapping.tmpl = tp
+		}
+
+		mapping.From = opts.sourcePath(mapping.From)
+
+		// default As to symlink
+		if len(mapping.As) == 0 {
+			mapping.As = "link"
+		}
+		mapping.Relative = mapping.Relative || opts.Relative && (mapping.As == "link" || mapping.As == "tree")
+
+		newDots.Warnings = append(newDots.Warnings, mapping.secretsWarnings()...)
+
+		newDots.FileMappings = append(newDots.FileMappings, mapping)
+	}
+	for _, resource := range dots.Resources {
+		if len(resource.To) > 0 {
+			resource.To = expandTilde(resource.To)
+		}
+
+		newDots.Resources = append(newDots.Resources, resource)
+	}
+	for _, script := range dots.Scripts {
+		// watched files live in the source tree, like mapped ones
+		var watch []string
+		for _, watched := range script.Watch {
+			watch = append(watch, opts.sourcePath(watched))
+		}
+		script.Watch = watch
+		script.ignore = opts.newIgnorer(patterns, nil, ".")
+
+		if len(script.When) == 0 {
+			script.When = RunOnce
+		}
+
+		newDots.Scripts = append(newDots.Scripts, script)
+	}
+
+	return newDots, errs
+}
+
+// sourcePath resolves a file in the source tree: under `opt.cd`, relative to
+// the current directory
+func (opts Opts) sourcePath(file string) string {
+	if len(opts.Cd) > 0 {
+		// Cd set: add prefix to file
+		file = path.Join(opts.Cd, file)
+	}
+	if !strings.HasPrefix(file, "/") {
+		cwd, _ := os.Getwd()
+		file = cwd + "/" + file
+	}
+	return file
+}
+
+func fetchGitResource(ctx context.Context, resource Resource, o Options) error {
+	if err := createPathMode(resource.To, resource.DirMode.orDefault(defaultDirMode)); err != nil {
+		return err
+	}
+	_, err := git.PlainCloneContext(ctx, resource.To, false, &git.CloneOptions{
+		URL:      resource.Url,
+		Progress: o.Progress,
+	})
+
+	return err
+}
+
+// download requests url, for its body to be read
+func download(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	httpClient := http.Client{}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func fetchHttpResource(ctx context.Context, resource Resource) error {
+	body, err := download(ctx, resource.Url)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if !pathExists(resource.To) {
+		if err := createPathMode(resource.To, resource.DirMode.orDefault(defaultDirMode)); err != nil {
+			return err
+		}
+	}
+
+	mode := resource.Mode.orDefault(0666)
+	fout, err := os.OpenFile(resource.destination(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer fout.Close()
+
+	if _, err := io.Copy(fout, body); err != nil {
+		return err
+	}
+
+	if resource.Mode != 0 {
+		return fout.Chmod(mode)
+	}
+	return nil
+}
+
+func (resource Resource) destination() string {
+	if resource.As == "file" && strings.HasSuffix(resource.To, "/") {
+		return filepath.Join(resource.To, path.Base(resource.Url))
+	}
+	return resource.To
+}
+
+func isGitClone(dir, url string) bool {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return false
+	}
+	remote, err := repo.Remote("origin")
+	if err != nil {
+		return false
+	}
+	for _, remoteUrl := range remote.Config().URLs {
+		if remoteUrl == url {
+			return true
+		}
+	}
+	return false
+}
+
+func isDirtyGitClone(dir string) bool {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return false
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return false
+	}
+	status, err := worktree.Status()
+	return err == nil && !status.IsClean()
+}
+
+func (resource Resource) isUpToDate() bool {
+	return resource.As == "git" && isGitClone(resource.To, resource.Url)
+}
+
+// isDownloaded reports whether the file dot downloaded before is still
+// there as it left it, with the contents the URL serves now; telling takes
+// downloading it again
+func (resource Resource) isDownloaded(st *State) bool {
+	dest := resource.destination()
+	entry, ok := st.Targets[absPath(dest)]
+	if !ok || entry.As != "file" || !st.owns(dest) {
+		return false
+	}
+	fileInfo, err := os.Stat(dest)
+	if err != nil || fileInfo.Mode().Perm() != entry.Mode || entry.Mode != resource.Mode.orDefault(entry.Mode) {
+		return false
+	}
+	body, err := download(context.Background(), resource.Url)
+	if err != nil {
+		return false
+	}
+	defer body.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return false
+	}
+	return hex.EncodeToString(h.Sum(nil)) == entry.Hash
+}
+
+func (resource Resource) isManaged(st *State) bool {
+	return st.owns(resource.destination()) || resource.isUpToDate()
+}
+
+func fetchResource(ctx context.Context, resource Resource, o Options) error {
+	switch resource.As {
+	case "git":
+		return fetchGitResource(ctx, resource, o)
+	case "file":
+		return fetchHttpResource(ctx, resource)
+	}
+
+	return nil
+}
+
+/*
+ * Helpers
+ */
+
+func createPath(p string) error {
+	return createPathMode(p, defaultDirMode)
+}
+
+// firstMissingDir returns the outermost directory in dir's path that does
+// not exist yet, or "" if dir exists
+func firstMissingDir(dir string) string {
+	missing := ""
+	for !pathExists(dir) {
+		missing = dir
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return missing
+}
+
+func pathExists(path string) bool {
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return false
+	}
+	return true
+}
+
+func isSymlink(path string) bool {
+	fileInfo, err := os.Lstat(path)
+	return err == nil && fileInfo.Mode()&os.ModeSymlink != 0
+}
+
+func isDirectory(path string) bool {
+	fileInfo, err := os.Stat(path)
+	return err == nil && fileInfo.IsDir()
+}
+
+func getHomeDir() string {
+	return os.Getenv("HOME")
+}
+
+func expandTilde(path string) string {
+	if strings.HasPrefix(path, "~") {
+		homeDir := getHomeDir()
+		path = strings.Replace(path, "~", homeDir, 1)
+	}
+	return path
+}
+
+// Decode parses a dots file as written, rejecting unknown fields
+func Decode(data []byte) (Dots, error) {
+	var dots Dots
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(&dots); err != nil {
+		return Dots{}, fmt.Errorf("cannot decode data: %v", err)
+	}
+	return dots, nil
+}
+
+// Load reads, transforms and validates a dots file; if it is invalid, the
+// error is a ValidationError
+func Load(file string) (Dots, error) {
+	return LoadWithVars(file, nil)
+}
+
+// LoadWithVars is Load, with variables set over the dots file's own
+func LoadWithVars(file string, set map[string]interface{}) (Dots, error) {
+	rcFileData, err := os.ReadFile(file)
+	if err != nil {
+		return Dots{}, fmt.Errorf("error reading config data: %v", err)
+	}
+
+	dots, err := Decode(rcFileData)
+	if err != nil {
+		return Dots{}, err
+	}
+	dots.Set = set
+	dots.File = absPath(file)
+
+	newDots, errs := dots.Transform()
+	errs = append(errs, newDots.Validate()...)
+	if len(errs) > 0 {
+		return Dots{}, ValidationError{errs}
+	}
+
+	return newDots, nil
+}
@@ -1,17 +1,42 @@
-package main
+package dot
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/yaml.v3"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"runtime"
 	"testing"
 )
 
-func isSymlink(path string) bool {
-	fInfo, _ := os.Lstat(path)
-	return fInfo.Mode()&os.ModeSymlink != 0
+// the tests use the examples and fixtures at the root of the repository,
+// copied into a directory of their own: the command's tests map into the
+// root's out/ while these run
+func TestMain(m *testing.M) {
+	os.Exit(func() int {
+		dir, err := os.MkdirTemp("", "dot-test")
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		defer os.RemoveAll(dir)
+		for _, tree := range []string{"examples", "fixtures"} {
+			if err := cloneTree(filepath.Join("../..", tree), filepath.Join(dir, tree)); err != nil {
+				fmt.Println(err)
+				return 1
+			}
+		}
+		if err := os.Chdir(dir); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		return m.Run()
+	}())
 }
 
 /*
@@ -111,14 +136,18 @@ func TestUnmap(t *testing.T) {
 	}
 	assert.Nil(t, m.doLink())
 
-	unmapPath(m.To)
+	tx := begin(newTestState(t), Options{}, "", newBackupStamp(t.TempDir()))
+	assert.Nil(t, tx.unmapPath(m.To))
 	assert.False(t, pathExists(m.To))
+	tx.commit()
+	assert.False(t, pathExists(tx.trashPath(m.To)))
 }
 
 func TestDoMap(t *testing.T) {
 	defer func() {
 		_ = os.RemoveAll("out")
 	}()
+	st := newTestState(t)
 
 	// creates a symlink
 	m := FileMapping{
@@ -127,7 +156,9 @@ func TestDoMap(t *testing.T) {
 		As:   "link",
 	}
 
-	m.domap()
+	changed, err := m.domap(st, Options{})
+	assert.Nil(t, err)
+	assert.True(t, changed)
 	assert.True(t, isSymlink(m.To))
 
 	// creates path
@@ -140,14 +171,16 @@ func TestDoMap(t *testing.T) {
 		As:   "copy",
 	}
 
-	m.domap()
+	changed, err = m.domap(st, Options{})
+	assert.Nil(t, err)
+	assert.True(t, changed)
 	assert.False(t, isSymlink(m.To))
 
 	// same contents
 
 	var fromContents []byte
 	var toContents []byte
-	fromContents, err := os.ReadFile(m.From)
+	fromContents, err = os.ReadFile(m.From)
 	assert.Nil(t, err)
 	toContents, err = os.ReadFile(m.To)
 	assert.Nil(t, err)
@@ -182,7 +215,7 @@ func TestValidate(t *testing.T) {
 			},
 		},
 	}
-	errs := d.validate()
+	errs := d.Validate()
 	assert.Nil(t, errs)
 
 	// invalid dots: path does not exist
@@ -196,11 +229,11 @@ func TestValidate(t *testing.T) {
 			},
 		},
 	}
-	errs = d.validate()
+	errs = d.Validate()
 	assert.Equal(t, len(errs), 1)
 	assert.Contains(t, errs, fmt.Errorf("%s: path does not exist", d.FileMappings[0].From))
 
-	// invalid dots: copy on directory
+	// valid dots: copy on directory
 	d = Dots{
 		Opts: Opts{
 			Cd: "foo",
@@ -212,9 +245,8 @@ func TestValidate(t *testing.T) {
 			},
 		},
 	}
-	errs = d.validate()
-	assert.Equal(t, len(errs), 1)
-	assert.Contains(t, errs, fmt.Errorf("%s: cannot use copy type with directory", d.FileMappings[0].From))
+	errs = d.Validate()
+	assert.Nil(t, errs)
 
 	// invalid dots: missing resource destination
 	d = Dots{
@@ -226,7 +258,7 @@ func TestValidate(t *testing.T) {
 			},
 		},
 	}
-	errs = d.validate()
+	errs = d.Validate()
 	assert.Equal(t, 1, len(errs))
 	assert.Contains(t, errs, fmt.Errorf("%s: resource destination (`to`) cannot be empty", d.Resources[0].Url))
 
@@ -240,7 +272,7 @@ func TestValidate(t *testing.T) {
 			},
 		},
 	}
-	errs = d.validate()
+	errs = d.Validate()
 	assert.Equal(t, 1, len(errs))
 	assert.Contains(t, errs, fmt.Errorf("%s: resource type (`as`) cannot be empty", d.Resources[0].Url))
 }
@@ -258,7 +290,8 @@ func TestTransform(t *testing.T) {
 			},
 		},
 	}
-	dNew := d.transform()
+	dNew, errs := d.Transform()
+	assert.Empty(t, errs)
 	assert.NotNil(t, dNew)
 
 	// expands prefix
@@ -290,7 +323,8 @@ func TestTransform(t *testing.T) {
 			Cd: "foo",
 		},
 	}
-	dNew = d.transform()
+	dNew, errs = d.Transform()
+	assert.Empty(t, errs)
 	assert.NotNil(t, dNew)
 	assert.Equal(t, dNew.FileMappings[0].From, cwd+"/foo/examples/zshrc")
 
@@ -304,14 +338,16 @@ func TestTransform(t *testing.T) {
 		},
 	}
 
-	dNew = d.transform()
+	dNew, errs = d.Transform()
+	assert.Empty(t, errs)
 	assert.NotNil(t, dNew)
 	assert.Equal(t, home+"/some/path/to/file", dNew.Resources[0].To)
 }
 
 func TestReadDotFile(t *testing.T) {
 	f := "examples/01-dots-basic.yml"
-	dots := readDotFile(f)
+	dots, err := Load(f)
+	assert.Nil(t, err)
 	assert.NotNil(t, dots)
 
 	assert.Equal(t, dots.Opts.Cd, "examples/")
@@ -415,9 +451,28 @@ func TestEvalTemplateString(t *testing.T) {
 		"v1": "a value",
 	}
 	for templ, want := range cases {
-		got := evalTemplateString(templ, env)
+		got, err := evalTemplateString(templ, env, "")
+		assert.Nil(t, err)
 		assert.Equal(t, want, got)
 	}
+
+	_, err := evalTemplateString("password: hunter2\n{{ .v1 ", env, "")
+	assert.NotNil(t, err)
+	assert.NotContains(t, err.Error(), "hunter2")
+}
+
+func TestTemplateErrorsNameTheFile(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "config.json")
+	assert.Nil(t, os.WriteFile(src, []byte(`{"auth": "hunter2", "os": "{{ .Os }"}`), 0600))
+	d, errs := Dots{FileMappings: []FileMapping{
+		{From: src, To: filepath.Join(t.TempDir(), "config.json"), As: "copy", With: map[string]string{"Name": "dot"}},
+	}}.Transform()
+	assert.Empty(t, errs)
+
+	r := d.Apply(context.Background(), newTestState(t), Options{})
+	assert.Equal(t, 1, len(r.Failures))
+	assert.Contains(t, r.Failures[0].Err.Error(), src+": failed parsing template")
+	assert.NotContains(t, r.Failures[0].Err.Error(), "hunter2")
 }
 
 func TestEvalTemplate(t *testing.T) {
@@ -434,7 +489,8 @@ func TestEvalTemplate(t *testing.T) {
 		"t3": "{{if eq .Os \"" + otherOs + "\"}}must not be this{{else}}else{{end}}",
 	}
 
-	res := evalTemplate(with)
+	res, err := evalTemplate(with, nil)
+	assert.Nil(t, err)
 	assert.Equal(t, "it works", res["t1"])
 	assert.Equal(t, "", res["t2"], "")
 	assert.Equal(t, "else", res["t3"])
@@ -451,7 +507,7 @@ func TestFetchGitResource(t *testing.T) {
 		To:  to,
 		As:  "git",
 	}
-	err := fetchGitResource(resource)
+	err := fetchGitResource(context.Background(), resource, Options{})
 	assert.Nil(t, err)
 	assert.True(t, pathExists(to))
 	assert.True(t, pathExists(to+"/.git"))
@@ -468,7 +524,7 @@ func TestFetchHttpResource(t *testing.T) {
 		To:  to,
 		As:  "file",
 	}
-	err := fetchHttpResource(resource)
+	err := fetchHttpResource(context.Background(), resource)
 	assert.Nil(t, err)
 	assert.True(t, pathExists(to))
 	assert.True(t, pathExists(to) && !isDirectory(to))
@@ -485,7 +541,7 @@ func TestFetchHttpResourceCreatesPath(t *testing.T) {
 		To:  to,
 		As:  "file",
 	}
-	err := fetchHttpResource(resource)
+	err := fetchHttpResource(context.Background(), resource)
 	assert.Nil(t, err)
 	assert.True(t, pathExists(to))
 	assert.True(t, pathExists(to) && !isDirectory(to))
@@ -503,8 +559,114 @@ func TestFetchHttpResourceAddsFileNameIfEndingWithSlash(t *testing.T) {
 		To:  to,
 		As:  "file",
 	}
-	err := fetchHttpResource(resource)
+	err := fetchHttpResource(context.Background(), resource)
 	assert.Nil(t, err)
 	assert.True(t, pathExists(fullPath))
 	assert.True(t, pathExists(fullPath) && !isDirectory(fullPath))
 }
+
+func TestDownloadsOnlyWhatChanged(t *testing.T) {
+	content := "#!/bin/sh\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(content))
+	}))
+	defer srv.Close()
+
+	changes := filepath.Join(t.TempDir(), "changes")
+	d := Dots{
+		Opts: Opts{Backup: t.TempDir()},
+		Resources: []Resource{{
+			Url: srv.URL + "/install.sh", To: filepath.Join(t.TempDir(), "install.sh"), As: "file", Mode: 0755,
+			Hooks: Hooks{OnChange: "echo >> " + changes},
+		}},
+	}
+	st := newTestState(t)
+	for _, applied := range []int{1, 0, 0} {
+		r := d.Apply(context.Background(), st, Options{})
+		assert.Empty(t, r.Failures)
+		assert.Equal(t, applied, r.Applied)
+		assert.Equal(t, 1-applied, r.Unchanged)
+	}
+	assert.Equal(t, "\n", readString(t, changes))
+
+	// what the URL serves changed
+	content = "#!/bin/sh\nexit 0\n"
+	assert.Equal(t, []string{ActionRemove, ActionDownload, ActionHook}, kinds(d.Plan(st, Options{})))
+	assert.Equal(t, 1, d.Apply(context.Background(), st, Options{}).Applied)
+	assert.Equal(t, content, readString(t, d.Resources[0].To))
+	assert.Equal(t, "\n\n", readString(t, changes))
+
+	// as did its mode
+	d.Resources[0].Mode = 0700
+	assert.Equal(t, []string{ActionRemove, ActionDownload, ActionHook}, kinds(d.Plan(st, Options{})))
+}
+
+func TestIsUpToDate(t *testing.T) {
+	to := "out/"
+	assert.Nil(t, createPath(to))
+	defer func() {
+		_ = os.RemoveAll(to)
+	}()
+	st := newTestState(t)
+
+	// link
+	m := FileMapping{From: "examples/zshrc", To: "out/zshrc", As: "link"}
+	assert.False(t, m.isUpToDate(st))
+	assert.Nil(t, m.doLink())
+	assert.True(t, m.isUpToDate(st))
+	m.From = "examples/gitconfig"
+	assert.False(t, m.isUpToDate(st))
+
+	// copy
+	m = FileMapping{From: "examples/gitconfig", To: "out/gitconfig", As: "copy"}
+	assert.Nil(t, m.doCopy())
+	assert.True(t, m.isUpToDate(st))
+	assert.Nil(t, st.record(m.To, StateEntry{Source: m.From, As: m.As}))
+	assert.True(t, m.isUpToDate(st))
+
+	// mode differs from the one dot created it with
+	assert.Nil(t, os.Chmod(m.To, 0700))
+	assert.False(t, m.isUpToDate(st))
+
+	// contents differ
+	assert.Nil(t, os.Chmod(m.To, st.Targets[absPath(m.To)].Mode))
+	assert.Nil(t, os.WriteFile(m.To, []byte("changed"), 0644))
+	assert.False(t, m.isUpToDate(st))
+}
+
+func TestDoMapUnchanged(t *testing.T) {
+	to := "out/"
+	assert.Nil(t, createPath(to))
+	defer func() {
+		_ = os.RemoveAll(to)
+	}()
+	st := newTestState(t)
+
+	m := FileMapping{From: "examples/gitconfig", To: "out/gitconfig", As: "copy"}
+	changed, err := m.domap(st, Options{})
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	before, err := os.Stat(m.To)
+	assert.Nil(t, err)
+
+	// leaves the target untouched
+	changed, err = m.domap(st, Options{})
+	assert.Nil(t, err)
+	assert.False(t, changed)
+	after, err := os.Stat(m.To)
+	assert.Nil(t, err)
+	assert.Equal(t, before.ModTime(), after.ModTime())
+
+	// planned as unchanged instead of remove and copy
+	assert.Equal(t, []string{ActionUnchanged}, kinds(m.plan(Opts{}, st, Options{})))
+}
+
+func TestLoadErrors(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "dot.yml")
+	assert.Nil(t, os.WriteFile(f, []byte("map:\n  nonexistent:\n  zshrc:\n    as: copy\n    with:\n      v: '{{ .Os '\nopt:\n  cd: examples\n"), 0644))
+
+	_, err := Load(f)
+	var verr ValidationError
+	assert.True(t, errors.As(err, &verr))
+	assert.Equal(t, 2, len(verr.Errs))
+}
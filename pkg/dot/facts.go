@@ -0,0 +1,129 @@
+package dot
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+ * facts: what templates can tell about the machine dot runs on
+ */
+
+const osReleaseFile = "/etc/os-release"
+
+// Facts describes the machine, as `.Facts` in templates; whatever cannot be
+// found out is left empty
+type Facts struct {
+	Os            string `json:"os"`
+	Arch          string `json:"arch"`
+	Hostname      string `json:"hostname"`
+	Username      string `json:"username"`
+	Home          string `json:"home"`
+	Distro        string `json:"distro"`
+	DistroVersion string `json:"distro_version"`
+	Kernel        string `json:"kernel"`
+	Cpus          int    `json:"cpus"`
+	Shell         string `json:"shell"`
+}
+
+// Fact is a single fact, named the way templates refer to it
+type Fact struct {
+	Name  string
+	Value string
+}
+
+// GatherFacts finds out the facts about the machine; they are only
+// gathered once, and the same ones returned after that
+func GatherFacts() Facts {
+	return gatherOnce()
+}
+
+var gatherOnce = sync.OnceValue(func() Facts {
+	return gatherFacts(osReleaseFile)
+})
+
+func gatherFacts(osRelease string) Facts {
+	facts := Facts{
+		Os:   runtime.GOOS,
+		Arch: runtime.GOARCH,
+		Home: getHomeDir(),
+		Cpus: runtime.NumCPU(),
+	}
+	if shell := os.Getenv("SHELL"); len(shell) > 0 {
+		facts.Shell = filepath.Base(shell)
+	}
+	facts.Hostname, _ = os.Hostname()
+	if u, err := user.Current(); err == nil {
+		facts.Username = u.Username
+	} else {
+		facts.Username = os.Getenv("USER")
+	}
+	if out, err := exec.Command("uname", "-r").Output(); err == nil {
+		facts.Kernel = strings.TrimSpace(string(out))
+	}
+	release := readOsRelease(osRelease)
+	facts.Distro = release["ID"]
+	facts.DistroVersion = release["VERSION_ID"]
+	return facts
+}
+
+// readOsRelease reads the variables in an os-release(5) file, if there is
+// one
+func readOsRelease(path string) map[string]string {
+	vars := map[string]string{}
+	f, err := os.Open(path)
+	if err != nil {
+		return vars
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok || strings.HasPrefix(name, "#") {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `'"`)
+		}
+		vars[name] = value
+	}
+	return vars
+}
+
+// Fields lists the facts in order
+func (f Facts) Fields() []Fact {
+	v := reflect.ValueOf(f)
+	var fields []Fact
+	for i := 0; i < v.NumField(); i++ {
+		fields = append(fields, Fact{Name: v.Type().Field(i).Name, Value: fmt.Sprint(v.Field(i).Interface())})
+	}
+	return fields
+}
+
+// templateData is what templates see: the variables, the facts, `.Os` as
+// it was before there were any, and the mapping's `with` values, the latter
+// taking precedence
+func templateData(vars map[string]interface{}, with map[string]string) map[string]interface{} {
+	data := map[string]interface{}{}
+	for variable, value := range vars {
+		data[variable] = value
+	}
+	data["Os"] = runtime.GOOS
+	data["Facts"] = GatherFacts()
+	for variable, value := range with {
+		data[variable] = value
+	}
+	return data
+}
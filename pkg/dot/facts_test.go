@@ -0,0 +1,56 @@
+package dot
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGatherFacts(t *testing.T) {
+	osRelease := filepath.Join(t.TempDir(), "os-release")
+	assert.Nil(t, os.WriteFile(osRelease, []byte(`# comment
+NAME="Fedora Linux"
+ID=fedora
+VERSION_ID='40'
+`), 0644))
+	t.Setenv("HOME", "/home/me")
+	t.Setenv("SHELL", "/usr/bin/zsh")
+
+	facts := gatherFacts(osRelease)
+	assert.Equal(t, runtime.GOOS, facts.Os)
+	assert.Equal(t, runtime.GOARCH, facts.Arch)
+	assert.Equal(t, "/home/me", facts.Home)
+	assert.Equal(t, "zsh", facts.Shell)
+	assert.Equal(t, "fedora", facts.Distro)
+	assert.Equal(t, "40", facts.DistroVersion)
+	assert.Equal(t, runtime.NumCPU(), facts.Cpus)
+	assert.NotEmpty(t, facts.Kernel)
+
+	// not every machine has one
+	facts = gatherFacts(filepath.Join(t.TempDir(), "nothere"))
+	assert.Empty(t, facts.Distro)
+
+	fields := facts.Fields()
+	assert.Equal(t, Fact{"Os", runtime.GOOS}, fields[0])
+	assert.Equal(t, "Cpus", fields[8].Name)
+}
+
+func TestFactsInTemplates(t *testing.T) {
+	with, err := evalTemplate(map[string]string{
+		"arch": "{{ .Facts.Arch }}",
+		"os":   "{{ .Os }}-{{ .Facts.Os }}",
+	}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, runtime.GOARCH, with["arch"])
+	assert.Equal(t, runtime.GOOS+"-"+runtime.GOOS, with["os"])
+
+	// file bodies see them too
+	src := filepath.Join(t.TempDir(), "config")
+	assert.Nil(t, os.WriteFile(src, []byte("{{ .Facts.Arch }} {{ .name }}\n"), 0644))
+	content, err := FileMapping{From: src, As: "copy", With: map[string]string{"name": "me"}}.content()
+	assert.Nil(t, err)
+	assert.Equal(t, runtime.GOARCH+" me\n", string(content))
+}
@@ -0,0 +1,244 @@
+package dot
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+ * folding: directories mapped as trees, a real directory per directory and
+ * a symlink per file, like GNU Stow does
+ */
+
+// treeFiles lists, relative to the mapping's source directory, every file
+// in it that gets a link of its own
+func (m FileMapping) treeFiles() ([]string, error) {
+	root, err := filepath.EvalSymlinks(m.From)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		if rel != "." && m.ignore.ignores(filepath.Join(m.From, rel), d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// fileLink is the mapping linking a single file of the tree
+func (m FileMapping) fileLink(rel string) *FileMapping {
+	return &FileMapping{From: filepath.Join(m.From, rel), To: filepath.Join(m.To, rel), As: "link", DirMode: m.DirMode, Relative: m.Relative}
+}
+
+func isRealDirectory(path string) bool {
+	return isDirectory(path) && !isSymlink(path)
+}
+
+// unlinkedFiles lists the files of the tree that are not linked yet
+func (m FileMapping) unlinkedFiles() ([]string, error) {
+	files, err := m.treeFiles()
+	if err != nil {
+		return nil, err
+	}
+	var unlinked []string
+	for _, rel := range files {
+		if !m.fileLink(rel).isLinked() {
+			unlinked = append(unlinked, rel)
+		}
+	}
+	return unlinked, nil
+}
+
+func (m FileMapping) isTreeLinked() bool {
+	unlinked, err := m.unlinkedFiles()
+	return err == nil && len(unlinked) == 0 && isRealDirectory(m.To)
+}
+
+// planTree links every file of the tree that is not linked yet, applying
+// the conflict policy to each one in the way, and removes the links made
+// for files since removed from the source, or ignored
+func (m FileMapping) planTree(opts Opts, st *State, o Options) []Action {
+	entry, recorded := st.Targets[absPath(m.To)]
+	recorded = recorded && entry.As == "tree"
+	if o.Unlink {
+		if !recorded {
+			return nil
+		}
+		return []Action{{Kind: ActionRemove, To: m.To}}
+	}
+
+	files, err := m.treeFiles()
+	if err != nil {
+		return []Action{{Kind: ActionFail, From: m.From, To: m.To, Reason: err.Error()}}
+	}
+	unlinked, _ := m.unlinkedFiles()
+	policy := conflictPolicy(m.Conflict, opts)
+	owned := map[string]bool{}
+	for _, rel := range entry.Files {
+		owned[rel] = recorded
+	}
+
+	var actions []Action
+	cleared := false
+	for _, rel := range unlinked {
+		link := m.fileLink(rel)
+		next := Action{Kind: ActionLink, From: link.From, To: link.To, mapping: link, tree: &m}
+		if !cleared && pathExists(m.To) && !isRealDirectory(m.To) {
+			// say, the whole directory linked: it makes way for the tree
+			managed := st.owns(m.To) || isLinkTo(m.To, m.From)
+			clear := clearActions(m.To, managed, policy, opts, next)
+			if policy == ConflictSkip || policy == ConflictFail {
+				return clear
+			}
+			actions = append(actions, clear...)
+			cleared = true
+			continue
+		}
+		if cleared {
+			actions = append(actions, next)
+			continue
+		}
+		managed := owned[rel] && isSymlink(link.To)
+		actions = append(actions, clearActions(link.To, managed, policy, opts, next)...)
+	}
+
+	inTree := map[string]bool{}
+	for _, rel := range files {
+		inTree[rel] = true
+	}
+	var stale []string
+	for _, rel := range entry.Files {
+		if !inTree[rel] {
+			stale = append(stale, rel)
+		}
+	}
+	sort.Strings(stale)
+	for _, rel := range stale {
+		link := m.fileLink(rel)
+		if isLinkTo(link.To, link.From) {
+			actions = append(actions, Action{Kind: ActionRemove, To: link.To, tree: &m})
+		}
+	}
+
+	if len(actions) == 0 {
+		return []Action{{Kind: ActionUnchanged, From: m.From, To: m.To, mapping: &m}}
+	}
+	return actions
+}
+
+// relTo returns path relative to the tree's target
+func (m FileMapping) relTo(path string) string {
+	rel, _ := filepath.Rel(m.To, path)
+	return rel
+}
+
+// linkTreeFile links a file of the tree, recording it, along with the
+// directories created to hold it, in the tree's state entry
+func (tx *transaction) linkTreeFile(a Action) error {
+	if pathExists(a.tree.To) && !isRealDirectory(a.tree.To) {
+		return fmt.Errorf("%s: not a directory", a.tree.To)
+	}
+	var dirs []string
+	for dir := filepath.Dir(a.To); !pathExists(dir); dir = filepath.Dir(dir) {
+		rel := a.tree.relTo(dir)
+		if strings.HasPrefix(rel, "..") {
+			break
+		}
+		dirs = append([]string{rel}, dirs...)
+	}
+	tx.creating(a.To)
+	if _, err := a.mapping.domap(tx.st, tx.o); err != nil {
+		return err
+	}
+	rel := a.tree.relTo(a.To)
+	return tx.updateTree(a.tree, func(entry *StateEntry) {
+		for _, file := range entry.Files {
+			if file == rel {
+				rel = ""
+			}
+		}
+		if len(rel) > 0 {
+			entry.Files = append(entry.Files, rel)
+		}
+		entry.Dirs = append(entry.Dirs, dirs...)
+	})
+}
+
+// unlinkTreeFile removes the link to a file since removed from the tree
+func (tx *transaction) unlinkTreeFile(a Action) error {
+	if err := tx.unmapPath(a.To); err != nil {
+		return err
+	}
+	rel := a.tree.relTo(a.To)
+	return tx.updateTree(a.tree, func(entry *StateEntry) {
+		var files []string
+		for _, file := range entry.Files {
+			if file != rel {
+				files = append(files, file)
+			}
+		}
+		entry.Files = files
+	})
+}
+
+func (tx *transaction) updateTree(tree *FileMapping, update func(*StateEntry)) error {
+	entry, ok := tx.st.Targets[absPath(tree.To)]
+	if !ok || entry.As != "tree" {
+		entry = StateEntry{Source: tree.From, As: "tree"}
+	}
+	entry.Files = append([]string(nil), entry.Files...)
+	entry.Dirs = append([]string(nil), entry.Dirs...)
+	update(&entry)
+	return tx.record(tree.To, entry)
+}
+
+// unfoldTree removes what dot created for a tree: the links it made that
+// still point where it made them point, and the directories it made once
+// they are empty; anything else in the tree is left alone
+func (tx *transaction) unfoldTree(target string) error {
+	entry := tx.st.Targets[absPath(target)]
+	for _, rel := range entry.Files {
+		link := filepath.Join(target, rel)
+		source := filepath.Join(entry.Source, rel)
+		if !isLinkTo(link, source) {
+			continue
+		}
+		// put back as it was, relative or not
+		linkTarget, err := os.Readlink(link)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(link); err != nil {
+			return fmt.Errorf("failed removing file %s, %v", link, err)
+		}
+		tx.onUndo(func() error {
+			return os.Symlink(linkTarget, link)
+		})
+	}
+	for i := len(entry.Dirs) - 1; i >= 0; i-- {
+		dir := filepath.Join(target, entry.Dirs[i])
+		if os.Remove(dir) == nil {
+			tx.onUndo(func() error {
+				return os.Mkdir(dir, 0750)
+			})
+		}
+	}
+	tx.o.debugf("unfolded %s\n", target)
+	return tx.forget(target)
+}
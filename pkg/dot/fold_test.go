@@ -0,0 +1,115 @@
+package dot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestFold(t *testing.T) (string, string) {
+	src := t.TempDir()
+	assert.Nil(t, os.MkdirAll(filepath.Join(src, "lua/plugins"), 0750))
+	assert.Nil(t, os.WriteFile(filepath.Join(src, "init.lua"), []byte("init"), 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(src, "lua/plugins/lsp.lua"), []byte("lsp"), 0644))
+
+	dst := filepath.Join(t.TempDir(), "nvim")
+	assert.Nil(t, os.MkdirAll(filepath.Join(dst, "lua"), 0750))
+	assert.Nil(t, os.WriteFile(filepath.Join(dst, "lazy-lock.json"), []byte("{}"), 0644))
+	return src, dst
+}
+
+func TestFoldTree(t *testing.T) {
+	src, dst := newTestFold(t)
+	d := Dots{FileMappings: []FileMapping{{From: src, To: dst, As: "tree"}}}
+	st := newTestState(t)
+
+	actions := d.Plan(st, Options{})
+	assert.Equal(t, []string{ActionLink, ActionLink}, kinds(actions))
+	assert.Equal(t, "link "+src+"/init.lua -> "+dst+"/init.lua", actions[0].String())
+	assert.Equal(t, StatusLinksMissing, d.Status(context.Background(), st)[0].Status)
+
+	r := d.Apply(context.Background(), st, Options{})
+	assert.Empty(t, r.Failures)
+	assert.Equal(t, 1, r.Applied)
+	assert.True(t, isLinkTo(filepath.Join(dst, "init.lua"), filepath.Join(src, "init.lua")))
+	assert.True(t, isLinkTo(filepath.Join(dst, "lua/plugins/lsp.lua"), filepath.Join(src, "lua/plugins/lsp.lua")))
+	assert.True(t, isRealDirectory(filepath.Join(dst, "lua/plugins")))
+	assert.Equal(t, "{}", readString(t, filepath.Join(dst, "lazy-lock.json")))
+
+	entry := st.Targets[dst]
+	assert.Equal(t, "tree", entry.As)
+	assert.Equal(t, []string{"init.lua", "lua/plugins/lsp.lua"}, entry.Files)
+	assert.Equal(t, []string{"lua/plugins"}, entry.Dirs)
+	assert.Equal(t, []string{ActionUnchanged}, kinds(d.Plan(st, Options{})))
+	assert.Equal(t, StatusOk, d.Status(context.Background(), st)[0].Status)
+
+	// a file gone from the source loses its link, a new one gains one
+	assert.Nil(t, os.Remove(filepath.Join(src, "init.lua")))
+	assert.Nil(t, os.WriteFile(filepath.Join(src, "after.lua"), []byte("after"), 0644))
+	assert.Equal(t, []string{ActionLink, ActionRemove}, kinds(d.Plan(st, Options{})))
+	r = d.Apply(context.Background(), st, Options{})
+	assert.Empty(t, r.Failures)
+	assert.False(t, pathExists(filepath.Join(dst, "init.lua")))
+	assert.Equal(t, []string{"lua/plugins/lsp.lua", "after.lua"}, st.Targets[dst].Files)
+
+	// unlinking leaves what is not dot's in place
+	r = d.Apply(context.Background(), st, Options{Unlink: true})
+	assert.Empty(t, r.Failures)
+	assert.False(t, pathExists(filepath.Join(dst, "after.lua")))
+	assert.False(t, pathExists(filepath.Join(dst, "lua/plugins")))
+	assert.True(t, isRealDirectory(filepath.Join(dst, "lua")))
+	assert.Equal(t, "{}", readString(t, filepath.Join(dst, "lazy-lock.json")))
+	assert.Empty(t, st.Targets)
+}
+
+func TestRollbackUnfoldKeepsRelativeLinks(t *testing.T) {
+	src, dst := newTestFold(t)
+	d := Dots{FileMappings: []FileMapping{{From: src, To: dst, As: "tree", Relative: true}}}
+	st := newTestState(t)
+	assert.Empty(t, d.Apply(context.Background(), st, Options{}).Failures)
+	link := filepath.Join(dst, "init.lua")
+	before, err := os.Readlink(link)
+	assert.Nil(t, err)
+	assert.False(t, filepath.IsAbs(before))
+
+	tx := begin(st, Options{}, "", newBackupStamp(t.TempDir()))
+	assert.Nil(t, tx.unfoldTree(dst))
+	assert.False(t, pathExists(link))
+	assert.Empty(t, tx.rollback(0))
+	after, err := os.Readlink(link)
+	assert.Nil(t, err)
+	assert.Equal(t, before, after)
+	assert.Equal(t, []string{ActionUnchanged}, kinds(d.Plan(st, Options{})))
+}
+
+func TestFoldTreeConflicts(t *testing.T) {
+	src, dst := newTestFold(t)
+	assert.Nil(t, os.WriteFile(filepath.Join(dst, "init.lua"), []byte("mine"), 0644))
+	d := Dots{FileMappings: []FileMapping{{From: src, To: dst, As: "tree", Conflict: ConflictSkip}}}
+	st := newTestState(t)
+
+	// the policy applies file by file
+	assert.Equal(t, []string{ActionSkip, ActionLink}, kinds(d.Plan(st, Options{})))
+	d.FileMappings[0].Conflict = ConflictOverwrite
+	assert.Equal(t, []string{ActionOverwrite, ActionLink, ActionLink}, kinds(d.Plan(st, Options{})))
+
+	// a directory linked as a whole makes way for the tree
+	assert.Nil(t, os.RemoveAll(dst))
+	assert.Nil(t, os.Symlink(src, dst))
+	assert.Equal(t, []string{ActionRemove, ActionLink, ActionLink}, kinds(d.Plan(st, Options{})))
+	r := d.Apply(context.Background(), st, Options{})
+	assert.Empty(t, r.Failures)
+	assert.True(t, isRealDirectory(dst))
+	assert.True(t, isLinkTo(filepath.Join(dst, "init.lua"), filepath.Join(src, "init.lua")))
+	assert.Equal(t, []string{".", "lua", "lua/plugins"}, st.Targets[dst].Dirs)
+}
+
+func TestValidateTree(t *testing.T) {
+	d := Dots{FileMappings: []FileMapping{{From: "examples/zshrc", As: "tree"}}}
+	errs := d.Validate()
+	assert.Equal(t, 1, len(errs))
+	assert.Equal(t, "examples/zshrc: tree type needs a directory", errs[0].Error())
+}
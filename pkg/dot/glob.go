@@ -0,0 +1,137 @@
+package dot
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+)
+
+/*
+ * globs: `map` keys standing for every file they match
+ */
+
+func isGlob(file string) bool {
+	return strings.ContainsAny(file, "*?[")
+}
+
+// globBase is the leading part of pattern without wildcards, where
+// matching starts from
+func globBase(pattern string) string {
+	var base []string
+	for _, seg := range strings.Split(pattern, "/") {
+		if isGlob(seg) {
+			break
+		}
+		base = append(base, seg)
+	}
+	return path.Join(base...)
+}
+
+// matchSegments matches a path against a pattern, both split on `/`; `**`
+// matches any number of segments, at least one when it ends the pattern, and
+// other segments match as in path.Match
+func matchSegments(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		first := 0
+		if len(pattern) == 1 {
+			first = 1
+		}
+		for i := first; i <= len(name); i++ {
+			if matchSegments(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	ok, err := path.Match(pattern[0], name[0])
+	return err == nil && ok && matchSegments(pattern[1:], name[1:])
+}
+
+// expandGlob lists, relative to root, the paths under root that match
+// pattern and are not ignored. With `**` only files match, so that a
+// directory and the files in it are not both matched; otherwise directories
+// match as well
+func expandGlob(root, pattern string, ig *ignorer) ([]string, error) {
+	segs := strings.Split(pattern, "/")
+	recursive := strings.Contains(pattern, "**")
+	dir := filepath.Join(root, globBase(pattern))
+	if !isDirectory(dir) {
+		return nil, nil
+	}
+
+	var matches []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == dir {
+			return nil
+		}
+		if ig.ignores(p, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, _ := filepath.Rel(root, p)
+		name := strings.Split(filepath.ToSlash(rel), "/")
+		if matchSegments(segs, name) && !(recursive && d.IsDir()) {
+			matches = append(matches, filepath.ToSlash(rel))
+		}
+		if d.IsDir() && !recursive && len(name) >= len(segs) {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return matches, err
+}
+
+// expandGlobs turns a mapping whose file is a glob into a mapping per
+// match, rendering `to` for each with the match as `.Path`, the match
+// relative to where the glob starts as `.Rel` and its base name as `.Name`.
+// Each mapping ignores what the source tree's patterns and its own do
+func (opts Opts) expandGlobs(mapping FileMapping, patterns []gitignore.Pattern) ([]FileMapping, error) {
+	if !isGlob(mapping.From) {
+		mapping.ignore = opts.newIgnorer(patterns, mapping.Ignore, mapping.From)
+		return []FileMapping{mapping}, nil
+	}
+	ig := opts.newIgnorer(patterns, mapping.Ignore, globBase(mapping.From))
+	matches, err := expandGlob(opts.sourcePath("."), mapping.From, ig)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) > 1 && len(mapping.To) > 0 && !strings.Contains(mapping.To, "{{") {
+		return nil, fmt.Errorf("glob matches %d files, but `to` does not tell them apart", len(matches))
+	}
+
+	var mappings []FileMapping
+	for _, match := range matches {
+		m := mapping
+		m.From = match
+		m.ignore = opts.newIgnorer(patterns, mapping.Ignore, match)
+		if len(mapping.To) > 0 {
+			rel, _ := filepath.Rel(globBase(mapping.From), match)
+			m.To, err = evalTemplateString(mapping.To, map[string]interface{}{
+				"Facts": GatherFacts(),
+				"Path":  match,
+				"Rel":   filepath.ToSlash(rel),
+				"Name":  path.Base(match),
+			}, opts.sourcePath("."))
+			if err != nil {
+				return nil, err
+			}
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings, nil
+}
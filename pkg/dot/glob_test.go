@@ -0,0 +1,89 @@
+package dot
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchSegments(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, name string
+		match         bool
+	}{
+		{"config/*.conf", "config/tmux.conf", true},
+		{"config/*.conf", "config/tmux/tmux.conf", false},
+		{"config/*", "config/nvim", true},
+		{"bin/**", "bin/x", true},
+		{"bin/**", "bin/a/b/x", true},
+		{"bin/**", "bin", false},
+		{"**/*.sh", "a/b/c.sh", true},
+		{"**/*.sh", "c.sh", true},
+		{"config/**/*.lua", "config/init.lua", true},
+		{"config/**/*.lua", "config/vim/init.vim", false},
+	} {
+		assert.Equal(t, tc.match, matchSegments(strings.Split(tc.pattern, "/"), strings.Split(tc.name, "/")), tc.pattern+" "+tc.name)
+	}
+}
+
+func newTestGlobTree(t *testing.T) string {
+	root := t.TempDir()
+	for _, file := range []string{"config/tmux.conf", "config/git.conf", "config/nvim/init.lua", "bin/a", "bin/sub/b"} {
+		assert.Nil(t, os.MkdirAll(filepath.Join(root, filepath.Dir(file)), 0750))
+		assert.Nil(t, os.WriteFile(filepath.Join(root, file), nil, 0644))
+	}
+	return root
+}
+
+func TestExpandGlob(t *testing.T) {
+	root := newTestGlobTree(t)
+
+	matches, err := expandGlob(root, "config/*.conf", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"config/git.conf", "config/tmux.conf"}, matches)
+
+	// directories match, unless with **
+	matches, err = expandGlob(root, "config/*", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"config/git.conf", "config/nvim", "config/tmux.conf"}, matches)
+	matches, err = expandGlob(root, "bin/**", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"bin/a", "bin/sub/b"}, matches)
+
+	matches, err = expandGlob(root, "nothere/*", nil)
+	assert.Nil(t, err)
+	assert.Empty(t, matches)
+}
+
+func TestTransformGlobs(t *testing.T) {
+	root := newTestGlobTree(t)
+	t.Setenv("HOME", "/home/me")
+	d := Dots{
+		Opts: Opts{Cd: root},
+		FileMappings: []FileMapping{
+			{From: "config/*.conf", As: "copy", Os: "linux"},
+			{From: "bin/**", To: "~/.local/bin/{{.Rel}}"},
+			{From: "config/nvim/*", To: "~/.config/nvim/{{.Name}}"},
+			{From: "config/*.toml"},
+		},
+	}
+	dNew, errs := d.Transform()
+	assert.Empty(t, errs)
+	assert.Equal(t, []FileMapping{
+		{From: root + "/config/git.conf", To: "/home/me/.config/git.conf", As: "copy", Os: "linux"},
+		{From: root + "/config/tmux.conf", To: "/home/me/.config/tmux.conf", As: "copy", Os: "linux"},
+		{From: root + "/bin/a", To: "/home/me/.local/bin/a", As: "link"},
+		{From: root + "/bin/sub/b", To: "/home/me/.local/bin/sub/b", As: "link"},
+		{From: root + "/config/nvim/init.lua", To: "/home/me/.config/nvim/init.lua", As: "link"},
+	}, dNew.FileMappings)
+	assert.Equal(t, []string{"config/*.toml: glob matches nothing"}, dNew.Warnings)
+
+	// every match needs a destination of its own
+	d.FileMappings = []FileMapping{{From: "config/*.conf", To: "~/.conf"}}
+	_, errs = d.Transform()
+	assert.Equal(t, 1, len(errs))
+	assert.Equal(t, "config/*.conf: glob matches 2 files, but `to` does not tell them apart", errs[0].Error())
+}
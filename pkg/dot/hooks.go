@@ -0,0 +1,110 @@
+package dot
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+/*
+ * hooks: commands run around mapping a file, fetching a resource, or a
+ * whole run
+ */
+
+const (
+	hookBefore   = "before"
+	hookAfter    = "after"
+	hookOnChange = "on_change"
+)
+
+type Hooks struct {
+	Before   string `yaml:"before"`
+	After    string `yaml:"after"`
+	OnChange string `yaml:"on_change"`
+}
+
+type hook struct {
+	dir string
+	env []string
+}
+
+// hookDir is where hooks run: the source tree
+func (opts Opts) hookDir() string {
+	if len(opts.Cd) > 0 {
+		return absPath(opts.Cd)
+	}
+	cwd, _ := os.Getwd()
+	return cwd
+}
+
+func hookEnv(source, dest, as string) []string {
+	env := append(os.Environ(), "DOT_OS="+runtime.GOOS)
+	if len(dest) > 0 {
+		env = append(env, "DOT_SOURCE="+source, "DOT_DEST="+dest, "DOT_AS="+as)
+	}
+	return env
+}
+
+// isSkipped reports whether nothing at all is done for the entry, in which
+// case its hooks do not run either
+func isSkipped(entry []Action) bool {
+	for _, action := range entry {
+		if action.Kind != ActionSkip {
+			return false
+		}
+	}
+	return true
+}
+
+func hookAction(name, command, dest string, h *hook) []Action {
+	if len(command) == 0 {
+		return nil
+	}
+	return []Action{{Kind: ActionHook, From: command, To: dest, Reason: name, hook: h}}
+}
+
+// withHooks surrounds the entry's actions with the hooks configured for it;
+// on_change is only planned when the entry changes something
+func withHooks(entry []Action, hooks Hooks, h *hook, dest string) []Action {
+	if isSkipped(entry) {
+		return entry
+	}
+	var actions []Action
+	actions = append(actions, hookAction(hookBefore, hooks.Before, dest, h)...)
+	actions = append(actions, entry...)
+	if !isNoop(entry) {
+		actions = append(actions, hookAction(hookOnChange, hooks.OnChange, dest, h)...)
+	}
+	actions = append(actions, hookAction(hookAfter, hooks.After, dest, h)...)
+	return actions
+}
+
+// runHook runs the hook's command
+func (a Action) runHook(ctx context.Context, o Options) error {
+	name := a.Reason
+	if len(a.To) > 0 {
+		name += " " + a.To
+	}
+	if err := runShell(ctx, "hook "+name, a.From, a.hook, o); err != nil {
+		return fmt.Errorf("hook %s `%s` failed: %v", name, a.From, err)
+	}
+	return nil
+}
+
+// runShell runs command with sh, logging its output line by line
+func runShell(ctx context.Context, name, command string, h *hook, o Options) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = h.dir
+	cmd.Env = h.env
+	out, err := cmd.CombinedOutput()
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		o.logf("%s: %s\n", name, scanner.Text())
+	}
+	return err
+}
@@ -0,0 +1,140 @@
+package dot
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanHooks(t *testing.T) {
+	to := "out/"
+	assert.Nil(t, createPath(to))
+	defer func() {
+		_ = os.RemoveAll(to)
+	}()
+
+	otherOs := "macos"
+	if !(FileMapping{Os: "linux"}).isMatchingOs() {
+		otherOs = "linux"
+	}
+
+	hooks := Hooks{Before: "true", After: "true", OnChange: "true"}
+	d := Dots{
+		Opts: Opts{Hooks: Hooks{Before: "echo start", OnChange: "echo changed"}},
+		FileMappings: []FileMapping{
+			{From: "examples/zshrc", To: "out/zshrc", As: "link", Hooks: hooks},
+			{From: "examples/zshrc", To: "out/other", As: "link", Os: otherOs, Hooks: hooks},
+		},
+	}
+
+	st := newTestState(t)
+	actions := d.Plan(st, Options{})
+	assert.Equal(t, []string{
+		ActionHook,
+		ActionHook, ActionLink, ActionHook, ActionHook,
+		ActionSkip,
+		ActionHook,
+	}, kinds(actions))
+	assert.Equal(t, "hook before: echo start", actions[0].String())
+	assert.Equal(t, "hook on_change out/zshrc: true", actions[3].String())
+	assert.Equal(t, hookAfter, actions[4].Reason)
+	assert.Equal(t, hookOnChange, actions[6].Reason)
+
+	// the run's own hooks are not counted as entries
+	r := d.Apply(context.Background(), st, Options{})
+	assert.Empty(t, r.Failures)
+	assert.Equal(t, 1, r.Applied)
+	assert.Equal(t, 1, r.Unchanged)
+
+	// nothing changes: no on_change hooks
+	r = d.Apply(context.Background(), st, Options{})
+	assert.Empty(t, r.Failures)
+	assert.Equal(t, 0, r.Applied)
+	assert.Equal(t, 2, r.Unchanged)
+	assert.Equal(t, []string{
+		ActionHook,
+		ActionHook, ActionUnchanged, ActionHook,
+		ActionSkip,
+	}, kinds(d.Plan(st, Options{})))
+
+	// nor any when only removing
+	assert.Equal(t, []string{ActionRemove, ActionSkip}, kinds(d.Plan(st, Options{Unlink: true})))
+}
+
+func TestRunHooks(t *testing.T) {
+	to := "out/"
+	assert.Nil(t, createPath(to))
+	defer func() {
+		_ = os.RemoveAll(to)
+	}()
+
+	env := filepath.Join(t.TempDir(), "env")
+	d := Dots{
+		Opts: Opts{Cd: "examples"},
+		FileMappings: []FileMapping{
+			{From: "examples/zshrc", To: "out/zshrc", As: "link", Hooks: Hooks{
+				OnChange: `echo "$DOT_SOURCE $DOT_DEST $DOT_AS $DOT_OS $PWD" > ` + env,
+				After:    "echo linked; echo twice",
+			}},
+		},
+	}
+	var logs bytes.Buffer
+	r := d.Apply(context.Background(), newTestState(t), Options{Logger: log.New(&logs, "", 0)})
+	assert.Empty(t, r.Failures)
+	assert.Equal(t, 1, r.Applied)
+
+	// runs in the source tree, with what is mapped in its environment
+	cwd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Equal(t, "examples/zshrc out/zshrc link "+runtime.GOOS+" "+filepath.Join(cwd, "examples")+"\n", readString(t, env))
+	assert.Equal(t, "hook after out/zshrc: linked\nhook after out/zshrc: twice\n", logs.String())
+}
+
+func TestFailingHookRollsBack(t *testing.T) {
+	to := "out/"
+	assert.Nil(t, createPath(to))
+	defer func() {
+		_ = os.RemoveAll(to)
+	}()
+
+	d := Dots{
+		FileMappings: []FileMapping{
+			{From: "examples/zshrc", To: "out/zshrc", As: "link", Hooks: Hooks{After: "echo oops; exit 3"}},
+		},
+	}
+	var logs bytes.Buffer
+	r := d.Apply(context.Background(), newTestState(t), Options{Logger: log.New(&logs, "", 0)})
+	assert.Equal(t, 1, len(r.Failures))
+	assert.Equal(t, "examples/zshrc", r.Failures[0].Entry)
+	assert.Equal(t, "hook after out/zshrc `echo oops; exit 3` failed: exit status 3", r.Failures[0].Err.Error())
+	assert.Equal(t, "hook after out/zshrc: oops\n", logs.String())
+	assert.False(t, pathExists("out/zshrc"))
+}
+
+func TestDecodeHooks(t *testing.T) {
+	dots, err := Decode([]byte(`
+map:
+  tmux.conf:
+    hooks:
+      on_change: tmux source-file ~/.tmux.conf
+fetch:
+  - url: https://github.com/vimwiki/vimwiki
+    to: ~/.vim/pack/plugins/start/vimwiki
+    as: git
+    hooks:
+      after: vim -u NONE -c "helptags doc" -c q
+opt:
+  hooks:
+    before: echo start
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, Hooks{OnChange: "tmux source-file ~/.tmux.conf"}, dots.FileMappings[0].Hooks)
+	assert.Equal(t, Hooks{After: `vim -u NONE -c "helptags doc" -c q`}, dots.Resources[0].Hooks)
+	assert.Equal(t, Hooks{Before: "echo start"}, dots.Opts.Hooks)
+}
@@ -0,0 +1,85 @@
+package dot
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+)
+
+/*
+ * ignoring: files in the source tree that are never mapped, as listed in
+ * its .dotignore and in mappings' `ignore`
+ */
+
+const ignoreFile = ".dotignore"
+
+// ignorer matches paths in the source tree, rooted at root, against
+// gitignore patterns
+type ignorer struct {
+	root    string
+	matcher gitignore.Matcher
+}
+
+func splitPath(p string) []string {
+	if p == "." || len(p) == 0 {
+		return nil
+	}
+	return strings.Split(filepath.ToSlash(p), "/")
+}
+
+// ignorePatterns reads the patterns in the source tree's .dotignore, if any
+func (opts Opts) ignorePatterns() ([]gitignore.Pattern, error) {
+	f, err := os.Open(filepath.Join(opts.sourcePath("."), ignoreFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []gitignore.Pattern
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "#") && len(strings.TrimSpace(line)) > 0 {
+			patterns = append(patterns, gitignore.ParsePattern(line, nil))
+		}
+	}
+	return patterns, scanner.Err()
+}
+
+// newIgnorer matches the source tree's patterns along with a mapping's own,
+// which are relative to the file it maps, key; it's nil when there is
+// nothing to match
+func (opts Opts) newIgnorer(patterns []gitignore.Pattern, ignore []string, key string) *ignorer {
+	if len(patterns) == 0 && len(ignore) == 0 {
+		return nil
+	}
+	domain := splitPath(key)
+	patterns = append([]gitignore.Pattern(nil), patterns...)
+	for _, p := range ignore {
+		patterns = append(patterns, gitignore.ParsePattern(p, domain))
+	}
+	return &ignorer{root: filepath.Clean(opts.sourcePath(".")), matcher: gitignore.NewMatcher(patterns)}
+}
+
+// ignores reports whether path is to be left alone; .dotignore itself
+// always is
+func (ig *ignorer) ignores(path string, isDir bool) bool {
+	if filepath.Base(path) == ignoreFile {
+		return true
+	}
+	if ig == nil {
+		return false
+	}
+	rel, err := filepath.Rel(ig.root, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	return ig.matcher.Match(splitPath(rel), isDir)
+}
@@ -0,0 +1,134 @@
+package dot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestIgnoreTree(t *testing.T) string {
+	root := t.TempDir()
+	files := map[string]string{
+		".dotignore":              "# housekeeping\n*.swp\n.DS_Store\n/README.md\n",
+		"README.md":               "",
+		"zshrc":                   "",
+		".zshrc.swp":              "",
+		"config/nvim/init.lua":    "",
+		"config/nvim/.init.swp":   "",
+		"config/nvim/README.md":   "",
+		"config/nvim/notes.txt":   "",
+		"config/kitty/kitty.conf": "",
+		"config/.DS_Store":        "",
+	}
+	for file, content := range files {
+		assert.Nil(t, os.MkdirAll(filepath.Join(root, filepath.Dir(file)), 0750))
+		assert.Nil(t, os.WriteFile(filepath.Join(root, file), []byte(content), 0644))
+	}
+	return root
+}
+
+func TestIgnorer(t *testing.T) {
+	root := newTestIgnoreTree(t)
+	opts := Opts{Cd: root}
+	patterns, err := opts.ignorePatterns()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(patterns))
+
+	ig := opts.newIgnorer(patterns, []string{"*.txt"}, "config/nvim")
+	assert.True(t, ig.ignores(root+"/.zshrc.swp", false))
+	assert.True(t, ig.ignores(root+"/config/nvim/.init.swp", false))
+	assert.True(t, ig.ignores(root+"/README.md", false))
+	assert.False(t, ig.ignores(root+"/config/nvim/README.md", false))
+	assert.True(t, ig.ignores(root+"/config/nvim/notes.txt", false))
+	assert.False(t, ig.ignores(root+"/notes.txt", false))
+	assert.False(t, ig.ignores(root+"/config/nvim/init.lua", false))
+	assert.False(t, ig.ignores("/elsewhere/x.swp", false))
+
+	// nothing to ignore but .dotignore itself
+	assert.Nil(t, Opts{}.newIgnorer(nil, nil, "config"))
+	assert.True(t, (*ignorer)(nil).ignores(root+"/.dotignore", false))
+	assert.False(t, (*ignorer)(nil).ignores(root+"/zshrc", false))
+}
+
+func TestIgnoreInTraversals(t *testing.T) {
+	root := newTestIgnoreTree(t)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	d, errs := Dots{
+		Opts: Opts{Cd: root},
+		FileMappings: []FileMapping{
+			{From: "*"},
+			{From: "config/*", To: "~/.config/{{.Name}}", As: "tree", Ignore: []string{"notes.txt"}},
+		},
+	}.Transform()
+	assert.Empty(t, errs)
+
+	// globs skip ignored files, and so do the trees they match
+	var froms []string
+	for _, mapping := range d.FileMappings {
+		froms = append(froms, mapping.From)
+	}
+	assert.Equal(t, []string{root + "/config", root + "/zshrc", root + "/config/kitty", root + "/config/nvim"}, froms)
+
+	nvim := d.FileMappings[3]
+	files, err := nvim.treeFiles()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"README.md", "init.lua"}, files)
+
+	// as do copies, which leave ignored files in the target alone
+	nvim.As = "copy"
+	assert.Nil(t, nvim.doCopy())
+	assert.False(t, pathExists(home+"/.config/nvim/.init.swp"))
+	assert.False(t, pathExists(home+"/.config/nvim/notes.txt"))
+	assert.Nil(t, os.WriteFile(home+"/.config/nvim/.new.swp", nil, 0644))
+	assert.True(t, nvim.isUpToDate(nil))
+
+	// and adopting
+	st := newTestState(t)
+	dotFile := filepath.Join(root, "dot.yml")
+	assert.Nil(t, os.WriteFile(dotFile, []byte("map:\n"), 0644))
+	assert.Nil(t, os.WriteFile(home+"/.vimrc.swp", nil, 0644))
+	err = d.Adopt(dotFile, home+"/.vimrc.swp", st, Options{})
+	assert.Equal(t, root+"/vimrc.swp: ignored by .dotignore", err.Error())
+	assert.True(t, pathExists(home+"/.vimrc.swp"))
+}
+
+func TestApplyKeepsIgnoredFilesInCopies(t *testing.T) {
+	root := t.TempDir()
+	src := filepath.Join(root, "app")
+	assert.Nil(t, os.Mkdir(src, 0750))
+	assert.Nil(t, os.WriteFile(filepath.Join(src, "prefs"), []byte("old\n"), 0644))
+	dst := filepath.Join(t.TempDir(), ".app")
+	backups := t.TempDir()
+	d, errs := Dots{
+		Opts:         Opts{Cd: root, Backup: backups},
+		FileMappings: []FileMapping{{From: "app", To: dst, As: "copy", Ignore: []string{"*.local"}}},
+	}.Transform()
+	assert.Empty(t, errs)
+	st := newTestState(t)
+	assert.Empty(t, d.Apply(context.Background(), st, Options{}).Failures)
+
+	// the application writes into the copy, then the source changes
+	assert.Nil(t, os.WriteFile(filepath.Join(dst, "prefs.local"), []byte("mine\n"), 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(dst, "cache"), []byte("stale\n"), 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(src, "prefs"), []byte("new\n"), 0644))
+	assert.Equal(t, []string{ActionCopy}, kinds(d.Plan(st, Options{})))
+
+	r := d.Apply(context.Background(), st, Options{})
+	assert.Empty(t, r.Failures)
+	assert.Equal(t, 1, r.Applied)
+	assert.Equal(t, "new\n", readString(t, filepath.Join(dst, "prefs")))
+	assert.Equal(t, "mine\n", readString(t, filepath.Join(dst, "prefs.local")))
+	assert.False(t, pathExists(filepath.Join(dst, "cache")))
+	trash, err := filepath.Glob(filepath.Join(filepath.Dir(dst), ".app.dot-*"))
+	assert.Nil(t, err)
+	assert.Empty(t, trash)
+	backed, err := os.ReadDir(backups)
+	assert.Nil(t, err)
+	assert.Empty(t, backed)
+	assert.Equal(t, []string{ActionUnchanged}, kinds(d.Plan(st, Options{})))
+}
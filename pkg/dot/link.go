@@ -0,0 +1,135 @@
+package dot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+/*
+ * links: symlinks, absolute or relative to the directory they are in, and
+ * hard links
+ */
+
+// linkTarget is what the mapping's symlink points to: its source, or with
+// `relative`, the way there from the directory the link is in
+func (m FileMapping) linkTarget() (string, error) {
+	if !m.Relative {
+		return m.linkSource(), nil
+	}
+	dir, err := realDir(filepath.Dir(absPath(m.To)))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Rel(dir, absPath(m.linkSource()))
+}
+
+// realDir resolves the symlinks in dir's path, as far as it exists: that is
+// where a relative link in it is resolved from
+func realDir(dir string) (string, error) {
+	missing := ""
+	for !pathExists(dir) {
+		missing = filepath.Join(filepath.Base(dir), missing)
+		dir = filepath.Dir(dir)
+	}
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(real, missing), nil
+}
+
+// checkRelative makes sure `relative` applies to the mapping, and that the
+// link it makes leads back to its source
+func (m FileMapping) checkRelative() error {
+	if m.As != "link" && m.As != "tree" {
+		return fmt.Errorf("`relative` is only supported in `link` and `tree` modes")
+	}
+	target, err := m.linkTarget()
+	if err != nil {
+		return fmt.Errorf("cannot link relatively: %v", err)
+	}
+	dir, _ := realDir(filepath.Dir(absPath(m.To)))
+	resolved := dir + string(filepath.Separator) + target
+	source := m.linkSource()
+	if filepath.Clean(resolved) != absPath(source) {
+		return fmt.Errorf("relative link %s would not resolve to the source", target)
+	}
+	// resolved by the filesystem, where it can be
+	if isDirectory(dir) && pathExists(source) {
+		fromInfo, fromErr := os.Stat(source)
+		toInfo, toErr := os.Stat(resolved)
+		if fromErr != nil || toErr != nil || !os.SameFile(fromInfo, toInfo) {
+			return fmt.Errorf("relative link %s would not resolve to the source", target)
+		}
+	}
+	return nil
+}
+
+// linkDest is where the symlink at path points to, relative links resolved
+func linkDest(path string) (string, error) {
+	dst, err := os.Readlink(path)
+	if err != nil || filepath.IsAbs(dst) {
+		return dst, err
+	}
+	dir, err := realDir(filepath.Dir(absPath(path)))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, dst), nil
+}
+
+// isLinkTo reports whether path is a symlink to target, as written or once
+// resolved
+func isLinkTo(path, target string) bool {
+	dst, err := os.Readlink(path)
+	if err != nil {
+		return false
+	}
+	if dst == target {
+		return true
+	}
+	dst, err = linkDest(path)
+	return err == nil && dst == target
+}
+
+// isLinked reports whether the mapping's target is the symlink it would
+// create, pointing to its source the same way
+func (m FileMapping) isLinked() bool {
+	target, err := m.linkTarget()
+	if err != nil {
+		return false
+	}
+	dst, err := os.Readlink(m.To)
+	return err == nil && dst == target
+}
+
+func (m FileMapping) doHardlink() error {
+	return os.Link(m.From, m.To)
+}
+
+func isSameFile(a, b string) bool {
+	aInfo, err := os.Lstat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Lstat(b)
+	return err == nil && os.SameFile(aInfo, bInfo)
+}
+
+// sameFilesystem reports whether a hard link at to, which need not exist
+// yet, could point to from; ok is false when that cannot be told
+func sameFilesystem(from, to string) (same, ok bool) {
+	for !pathExists(to) {
+		to = filepath.Dir(to)
+	}
+	fromDev, ok := device(from)
+	if !ok {
+		return false, false
+	}
+	toDev, ok := device(to)
+	if !ok {
+		return false, false
+	}
+	return fromDev == toDev, true
+}
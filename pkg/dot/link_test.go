@@ -0,0 +1,118 @@
+package dot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelativeLinks(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	assert.Nil(t, err)
+	source := filepath.Join(root, "dots/zshrc")
+	assert.Nil(t, os.MkdirAll(filepath.Dir(source), 0750))
+	assert.Nil(t, os.WriteFile(source, []byte("set -o vi\n"), 0644))
+
+	m := FileMapping{From: source, To: filepath.Join(root, "home/.zshrc"), As: "link", Relative: true}
+	st := newTestState(t)
+	changed, err := m.domap(st, Options{})
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	dst, err := os.Readlink(m.To)
+	assert.Nil(t, err)
+	assert.Equal(t, "../dots/zshrc", dst)
+	assert.Equal(t, "set -o vi\n", readString(t, m.To))
+	assert.True(t, m.isUpToDate(st))
+	assert.Equal(t, StatusOk, m.status(st).Status)
+
+	// still dot's link, just not the one an absolute mapping makes
+	assert.Nil(t, st.record(m.To, StateEntry{Source: m.From, As: "link"}))
+	assert.True(t, st.owns(m.To))
+	m.Relative = false
+	assert.False(t, m.isUpToDate(st))
+	assert.Equal(t, StatusWrongLinkTarget, m.status(st).Status)
+
+	// made from where the link really is
+	assert.Nil(t, os.Symlink(filepath.Join(root, "home"), filepath.Join(root, "alias")))
+	m = FileMapping{From: source, To: filepath.Join(root, "alias/config/zshrc"), As: "link", Relative: true}
+	target, err := m.linkTarget()
+	assert.Nil(t, err)
+	assert.Equal(t, "../../dots/zshrc", target)
+	assert.Nil(t, m.checkRelative())
+	_, err = m.domap(st, Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, "set -o vi\n", readString(t, m.To))
+	dst, err = linkDest(m.To)
+	assert.Nil(t, err)
+	assert.Equal(t, source, dst)
+}
+
+func TestTransformRelative(t *testing.T) {
+	d, errs := Dots{
+		Opts: Opts{Relative: true},
+		FileMappings: []FileMapping{
+			{From: "examples/zshrc"},
+			{From: "examples/gitconfig", As: "copy"},
+		},
+	}.Transform()
+	assert.Empty(t, errs)
+	assert.True(t, d.FileMappings[0].Relative)
+	assert.False(t, d.FileMappings[1].Relative)
+}
+
+func TestHardlinks(t *testing.T) {
+	root := t.TempDir()
+	source := filepath.Join(root, "gitconfig")
+	assert.Nil(t, os.WriteFile(source, []byte("[user]\n"), 0644))
+
+	m := FileMapping{From: source, To: filepath.Join(root, "home/.gitconfig"), As: "hardlink"}
+	st := newTestState(t)
+	assert.Equal(t, StatusMissing, m.status(st).Status)
+	changed, err := m.domap(st, Options{})
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.False(t, isSymlink(m.To))
+	assert.True(t, m.isUpToDate(st))
+	assert.Equal(t, StatusOk, m.status(st).Status)
+	assert.Nil(t, st.record(m.To, StateEntry{Source: m.From, As: "hardlink"}))
+
+	// replacing the source, as editors do, breaks the link, but what is
+	// left behind is still dot's
+	assert.Nil(t, os.WriteFile(source+".new", []byte("[user]\n"), 0644))
+	assert.Nil(t, os.Rename(source+".new", source))
+	assert.False(t, m.isUpToDate(st))
+	assert.Equal(t, StatusNotHardlink, m.status(st).Status)
+	assert.True(t, st.owns(m.To))
+	assert.Nil(t, os.WriteFile(m.To, []byte("[core]\n"), 0644))
+	assert.False(t, st.owns(m.To))
+}
+
+func TestValidateLinks(t *testing.T) {
+	d := Dots{
+		FileMappings: []FileMapping{
+			{From: "examples", To: "out/examples", As: "hardlink"},
+			{From: "examples/zshrc", To: "out/zshrc", As: "copy", Relative: true},
+			{From: "examples/zshrc", To: "out/nothere/zshrc", As: "hardlink"},
+			{From: "examples/zshrc", To: "out/nothere/zshrc", As: "link", Relative: true},
+		},
+	}
+	errs := d.Validate()
+	assert.Equal(t, 2, len(errs))
+	assert.Equal(t, "examples: cannot hard link a directory", errs[0].Error())
+	assert.Equal(t, "examples/zshrc: `relative` is only supported in `link` and `tree` modes", errs[1].Error())
+
+	same, ok := sameFilesystem("examples/zshrc", "out/nothere/zshrc")
+	assert.True(t, ok)
+	assert.True(t, same)
+
+	// /dev is a filesystem of its own, most everywhere
+	if same, ok := sameFilesystem("/dev/null", t.TempDir()); !ok || same {
+		t.Skip("no other filesystem to link across")
+	}
+	d.FileMappings = []FileMapping{{From: "/dev/null", To: "out/null", As: "hardlink"}}
+	errs = d.Validate()
+	assert.Equal(t, 1, len(errs))
+	assert.Equal(t, "/dev/null: cannot hard link across filesystems, to out/null", errs[0].Error())
+}
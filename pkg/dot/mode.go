@@ -0,0 +1,132 @@
+package dot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+ * modes: permissions of copied and fetched files, and of the directories
+ * created to hold them
+ */
+
+const defaultDirMode = 0750
+
+// Mode is a file mode as written in the dots file, in octal (e.g. 0600);
+// zero means it is not set
+type Mode os.FileMode
+
+func (m *Mode) UnmarshalYAML(node *yaml.Node) error {
+	value := strings.TrimPrefix(node.Value, "0o")
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return fmt.Errorf("line %d: invalid mode `%s`", node.Line, node.Value)
+	}
+	*m = Mode(mode)
+	return nil
+}
+
+func (m Mode) String() string {
+	return fmt.Sprintf("%04o", uint32(m))
+}
+
+func (m Mode) orDefault(mode os.FileMode) os.FileMode {
+	if m == 0 {
+		return mode
+	}
+	return os.FileMode(m)
+}
+
+// fileMode is the mode of the mapping's copy: `mode`, or else the source's
+func (m FileMapping) fileMode() (os.FileMode, error) {
+	if m.Mode != 0 {
+		return os.FileMode(m.Mode), nil
+	}
+	fileInfo, err := os.Stat(m.From)
+	if err != nil {
+		return 0, err
+	}
+	return fileInfo.Mode().Perm(), nil
+}
+
+// createPathMode creates the directories holding p that are missing, with
+// the given mode
+func createPathMode(p string, mode os.FileMode) error {
+	dir := filepath.Dir(p)
+	if pathExists(dir) {
+		return nil
+	}
+	created := firstMissingDir(dir)
+	if err := os.MkdirAll(dir, mode); err != nil {
+		return err
+	}
+	// not subject to the umask
+	for ; ; dir = filepath.Dir(dir) {
+		if err := os.Chmod(dir, mode); err != nil {
+			return err
+		}
+		if dir == created {
+			return nil
+		}
+	}
+}
+
+// secretPatterns is what commonly gives away credentials in a file
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`),
+	regexp.MustCompile(`"auths?"\s*:`),
+	regexp.MustCompile(`(?i)aws_secret_access_key`),
+	regexp.MustCompile(`(?i)\b(password|passwd|secret|token|api[_-]?key)\s*[:=]\s*\S`),
+	regexp.MustCompile(`\b(ghp|gho|github_pat|glpat|xox[bp])[_-][A-Za-z0-9_]{16,}`),
+}
+
+func looksSecret(content []byte) bool {
+	for _, pattern := range secretPatterns {
+		if pattern.Match(content) {
+			return true
+		}
+	}
+	return false
+}
+
+// secretsWarnings lists the files the mapping copies that look like they
+// hold secrets, but would be readable by group or others
+func (m FileMapping) secretsWarnings() []string {
+	if (m.As != "copy" && !m.isRenderedLink()) || !pathExists(m.From) {
+		return nil
+	}
+	var warnings []string
+	warn := func(file string, mode os.FileMode, content []byte) {
+		if mode&0044 != 0 && looksSecret(content) {
+			warnings = append(warnings, fmt.Sprintf("%s: looks like it holds secrets, but is copied readable by others (mode %04o); set `mode`", file, mode))
+		}
+	}
+	if isDirectory(m.From) {
+		entries, err := m.sourceTree()
+		if err != nil {
+			return nil
+		}
+		for _, entry := range entries {
+			if entry.mode.IsRegular() {
+				warn(filepath.Join(m.From, entry.rel), entry.mode.Perm(), entry.content)
+			}
+		}
+		return warnings
+	}
+	mode, err := m.fileMode()
+	if err != nil {
+		return nil
+	}
+	content, err := os.ReadFile(m.From)
+	if err != nil {
+		return nil
+	}
+	warn(m.From, mode, content)
+	return warnings
+}
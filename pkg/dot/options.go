@@ -0,0 +1,55 @@
+package dot
+
+import (
+	"io"
+)
+
+/*
+ * options: how a dots file is applied, set by whoever embeds dot
+ */
+
+// Logger receives dot's progress messages; *log.Logger is one
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+type Options struct {
+	// Verbose also logs what is left unchanged or skipped
+	Verbose bool
+	// NoRm maps without first removing what is at the destination
+	NoRm bool
+	// Unlink only removes the destinations dot owns, creating nothing
+	Unlink bool
+	// FetchOnly leaves file mappings alone, only fetching resources
+	FetchOnly bool
+	// KeepGoing keeps applying after a failure, only rolling back the
+	// entry it happened in
+	KeepGoing bool
+	// Logger receives progress messages; they are discarded if nil
+	Logger Logger
+	// Progress receives the progress of git clones, if set
+	Progress io.Writer
+	// Ask resolves the `ask` conflict policy for a destination, returning
+	// the policy to apply instead: overwrite, backup, skip or fail (any
+	// other answer fails the entry); by default, it prompts on the terminal
+	Ask func(target string) string
+}
+
+func (o Options) logf(format string, v ...interface{}) {
+	if o.Logger != nil {
+		o.Logger.Printf(format, v...)
+	}
+}
+
+func (o Options) debugf(format string, v ...interface{}) {
+	if o.Verbose {
+		o.logf(format, v...)
+	}
+}
+
+func (o Options) ask(target string) string {
+	if o.Ask != nil {
+		return o.Ask(target)
+	}
+	return promptConflict(target, o)
+}
@@ -0,0 +1,277 @@
+package dot
+
+import (
+	"fmt"
+)
+
+/*
+ * planning: the ordered list of actions applying a dots file performs
+ */
+
+const (
+	ActionRemove    = "remove"
+	ActionBackup    = "backup"
+	ActionPrune     = "prune"
+	ActionForget    = "forget"
+	ActionOverwrite = "overwrite"
+	ActionAsk       = "ask"
+	ActionFail      = "fail"
+	ActionLink      = "link"
+	ActionHardlink  = "hardlink"
+	ActionCopy      = "copy"
+	ActionRender    = "render"
+	ActionClone     = "clone"
+	ActionDownload  = "download"
+	ActionSkip      = "skip"
+	ActionUnchanged = "unchanged"
+	ActionHook      = "hook"
+	ActionRun       = "run"
+)
+
+type Action struct {
+	Kind   string
+	From   string
+	To     string
+	Reason string
+
+	mapping   *FileMapping
+	resource  *Resource
+	backupDir string
+	next      *Action
+	hook      *hook
+	script    *Script
+	tree      *FileMapping
+}
+
+func (a Action) String() string {
+	switch a.Kind {
+	case ActionRemove, ActionPrune, ActionOverwrite:
+		return fmt.Sprintf("%s %s", a.Kind, a.To)
+	case ActionAsk:
+		return fmt.Sprintf("%s %s: %s", a.Kind, a.To, a.next)
+	case ActionForget, ActionFail:
+		return fmt.Sprintf("%s %s: %s", a.Kind, a.To, a.Reason)
+	case ActionSkip:
+		return fmt.Sprintf("%s %s: %s", a.Kind, a.From, a.Reason)
+	case ActionUnchanged:
+		return fmt.Sprintf("%s %s", a.Kind, a.To)
+	case ActionHook:
+		if len(a.To) == 0 {
+			return fmt.Sprintf("%s %s: %s", a.Kind, a.Reason, a.From)
+		}
+		return fmt.Sprintf("%s %s %s: %s", a.Kind, a.Reason, a.To, a.From)
+	case ActionRun:
+		return fmt.Sprintf("%s %s (%s)", a.Kind, a.To, a.Reason)
+	default:
+		return fmt.Sprintf("%s %s -> %s", a.Kind, a.From, a.To)
+	}
+}
+
+// As is the type of the mapping or resource the action is for, if any
+func (a Action) As() string {
+	switch {
+	case a.mapping != nil:
+		return a.mapping.As
+	case a.resource != nil:
+		return a.resource.As
+	}
+	return ""
+}
+
+// source is what the action's target is recorded as made from: rendered
+// links are made from their rendering
+func (a Action) source() string {
+	if a.mapping != nil && a.mapping.isRenderedLink() {
+		return a.mapping.linkSource()
+	}
+	return a.From
+}
+
+// clearActions precedes next with whatever is needed to clear its target:
+// removing it when dot put it there, or applying the conflict policy
+// otherwise
+func clearActions(to string, managed bool, policy string, opts Opts, next Action) []Action {
+	if !pathExists(to) {
+		return []Action{next}
+	}
+	if managed {
+		return []Action{{Kind: ActionRemove, To: to}, next}
+	}
+	return conflictActions(to, policy, opts.backupDir(), next)
+}
+
+func conflictActions(to, policy, backupDir string, next Action) []Action {
+	switch policy {
+	case ConflictOverwrite:
+		return []Action{{Kind: ActionOverwrite, To: to}, next}
+	case ConflictSkip:
+		return []Action{{Kind: ActionSkip, From: next.From, To: to, Reason: "destination exists"}}
+	case ConflictFail:
+		return []Action{{Kind: ActionFail, From: next.From, To: to, Reason: "destination exists"}}
+	case ConflictAsk:
+		return []Action{{Kind: ActionAsk, From: next.From, To: to, next: &next, backupDir: backupDir}}
+	default:
+		backup := Action{Kind: ActionBackup, From: to, To: backupPath(backupDir, to), backupDir: backupDir}
+		return []Action{backup, next}
+	}
+}
+
+// removeOwnedAction is what rm-only mode does: remove the target only
+// when dot owns it, leaving anything else alone
+func removeOwnedAction(to string, managed bool) []Action {
+	if !pathExists(to) {
+		return nil
+	}
+	if !managed {
+		return []Action{{Kind: ActionSkip, From: to, To: to, Reason: "not managed by dot"}}
+	}
+	return []Action{{Kind: ActionRemove, To: to}}
+}
+
+func (m FileMapping) plan(opts Opts, st *State, o Options) []Action {
+	if !m.isMatchingOs() {
+		return []Action{{Kind: ActionSkip, From: m.From, To: m.To, Reason: "not on " + m.Os}}
+	}
+	if m.As == "tree" {
+		return m.planTree(opts, st, o)
+	}
+
+	kind := m.As
+	if (kind == "copy" || kind == "link") && m.renders() {
+		kind = ActionRender
+	}
+	next := Action{Kind: kind, From: m.From, To: m.To, mapping: &m}
+
+	if !o.NoRm { // remove before mapping by default
+		if o.Unlink {
+			return removeOwnedAction(m.To, m.isManaged(st))
+		}
+		if m.isUpToDate(st) {
+			return []Action{{Kind: ActionUnchanged, From: m.From, To: m.To, mapping: &m}}
+		}
+		if m.syncsInPlace(st) {
+			return []Action{next}
+		}
+		return clearActions(m.To, m.isManaged(st), conflictPolicy(m.Conflict, opts), opts, next)
+	}
+	return []Action{next}
+}
+
+func (r Resource) plan(opts Opts, st *State, o Options) []Action {
+	var next Action
+	switch {
+	case r.Skip:
+		next = Action{Kind: ActionSkip, From: r.Url, To: r.To, Reason: "skip set"}
+	case r.As == "git":
+		next = Action{Kind: ActionClone, From: r.Url, To: r.destination(), resource: &r}
+	case r.As == "file":
+		next = Action{Kind: ActionDownload, From: r.Url, To: r.destination(), resource: &r}
+	default:
+		next = Action{Kind: ActionSkip, From: r.Url, To: r.To, Reason: "unsupported type " + r.As}
+	}
+
+	if !o.NoRm { // remove before mapping by default
+		if o.Unlink {
+			return removeOwnedAction(r.destination(), r.isManaged(st))
+		}
+		if !r.Skip && (r.isUpToDate() || r.As == "file" && r.isDownloaded(st)) {
+			return []Action{{Kind: ActionUnchanged, From: r.Url, To: r.To, resource: &r}}
+		}
+		return clearActions(r.destination(), r.isManaged(st), conflictPolicy(r.Conflict, opts), opts, next)
+	}
+	return []Action{next}
+}
+
+// pruneActions removes what dot created for entries since dropped from the
+// dots file; targets modified after dot created them are left in place
+func (dots Dots) pruneActions(st *State) []Action {
+	var actions []Action
+	for _, target := range st.stale(dots) {
+		if !pathExists(target) {
+			actions = append(actions, Action{Kind: ActionForget, To: target, Reason: "no longer there"})
+		} else if st.Targets[target].As == "git" && isDirtyGitClone(target) {
+			actions = append(actions, Action{Kind: ActionForget, To: target, Reason: "has local changes"})
+		} else if st.owns(target) {
+			actions = append(actions, Action{Kind: ActionPrune, To: target})
+		} else {
+			actions = append(actions, Action{Kind: ActionForget, To: target, Reason: "modified since dot created it"})
+		}
+	}
+	return actions
+}
+
+func isResourceKind(as string) bool {
+	return as == "git" || as == "file"
+}
+
+// planEntries groups the actions by the entry they belong to: one group
+// per file mapping, resource or pruned target, along with their hooks, one
+// per script, and one per hook of the whole run
+func (dots Dots) planEntries(st *State, o Options) [][]Action {
+	if len(dots.Opts.stamp) == 0 {
+		dots.Opts.stamp = newBackupStamp(dots.Opts.backupRoot())
+	}
+	var entries [][]Action
+	for _, action := range dots.pruneActions(st) {
+		if o.FetchOnly && !isResourceKind(st.Targets[action.To].As) {
+			continue
+		}
+		entries = append(entries, []Action{action})
+	}
+	if !o.FetchOnly {
+		for _, mapping := range dots.FileMappings {
+			entry := mapping.plan(dots.Opts, st, o)
+			if !o.Unlink {
+				h := &hook{dots.Opts.hookDir(), hookEnv(mapping.From, mapping.To, mapping.As)}
+				entry = withHooks(entry, mapping.Hooks, h, mapping.To)
+			}
+			entries = append(entries, entry)
+		}
+	}
+	for _, resource := range dots.Resources {
+		entry := resource.plan(dots.Opts, st, o)
+		if !o.Unlink {
+			h := &hook{dots.Opts.hookDir(), hookEnv(resource.Url, resource.destination(), resource.As)}
+			entry = withHooks(entry, resource.Hooks, h, resource.destination())
+		}
+		entries = append(entries, entry)
+	}
+	if o.Unlink {
+		return entries
+	}
+	if !o.FetchOnly {
+		for _, script := range dots.Scripts {
+			entries = append(entries, script.plan(dots.Opts, st))
+		}
+	}
+
+	// the run's own hooks are entries of their own, around all the others
+	h := &hook{dots.Opts.hookDir(), hookEnv("", "", "")}
+	hooks := dots.Opts.Hooks
+	changed := false
+	for _, entry := range entries {
+		changed = changed || !isNoop(entry)
+	}
+	var all [][]Action
+	if before := hookAction(hookBefore, hooks.Before, "", h); before != nil {
+		all = append(all, before)
+	}
+	all = append(all, entries...)
+	if onChange := hookAction(hookOnChange, hooks.OnChange, "", h); changed && onChange != nil {
+		all = append(all, onChange)
+	}
+	if after := hookAction(hookAfter, hooks.After, "", h); after != nil {
+		all = append(all, after)
+	}
+	return all
+}
+
+// Plan lists, in order, the actions applying the dots file would perform,
+// without performing them
+func (dots Dots) Plan(st *State, o Options) []Action {
+	var actions []Action
+	for _, entry := range dots.planEntries(st, o) {
+		actions = append(actions, entry...)
+	}
+	return actions
+}
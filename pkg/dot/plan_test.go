@@ -0,0 +1,102 @@
+package dot
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func kinds(actions []Action) []string {
+	var ks []string
+	for _, a := range actions {
+		ks = append(ks, a.Kind)
+	}
+	return ks
+}
+
+func TestPlan(t *testing.T) {
+	to := "out/"
+	assert.Nil(t, createPath(to))
+	defer func() {
+		_ = os.RemoveAll(to)
+	}()
+
+	assert.Nil(t, os.WriteFile("out/existing", []byte("foo"), 0644))
+
+	otherOs := "macos"
+	if !(FileMapping{Os: "linux"}).isMatchingOs() {
+		otherOs = "linux"
+	}
+
+	d := Dots{
+		Opts: Opts{Backup: t.TempDir()},
+		FileMappings: []FileMapping{
+			{From: "examples/zshrc", To: "out/zshrc", As: "link"},
+			{From: "examples/gitconfig", To: "out/existing", As: "copy"},
+			{From: "fixtures/gpg-agent.conf.input", To: "out/gpg-agent.conf", As: "copy", With: map[string]string{"PinentryPath": "/foo"}},
+			{From: "examples/zshrc", To: "out/other", As: "link", Os: otherOs},
+		},
+		Resources: []Resource{
+			{Url: "https://example.com/repo", To: "out/repo", As: "git"},
+			{Url: "https://example.com/install.sh", To: "out/bin/", As: "file"},
+			{Url: "https://example.com/skipped", To: "out/skipped", As: "file", Skip: true},
+		},
+	}
+
+	st := newTestState(t)
+	actions := d.Plan(st, Options{})
+	assert.Equal(t, []string{
+		ActionLink,
+		ActionBackup, ActionCopy,
+		ActionRender,
+		ActionSkip,
+		ActionClone,
+		ActionDownload,
+		ActionSkip,
+	}, kinds(actions))
+
+	// resolves download destination
+	assert.Equal(t, "out/bin/install.sh", actions[6].To)
+
+	// no side effects
+	assert.False(t, pathExists("out/zshrc"))
+	assert.False(t, pathExists("out/gpg-agent.conf"))
+	content, err := os.ReadFile("out/existing")
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", string(content))
+
+	// rm-only: only removals, and never of files dot did not create
+	assert.Equal(t, []string{ActionSkip, ActionSkip}, kinds(d.Plan(st, Options{Unlink: true})))
+}
+
+func TestPlanDocumentOrder(t *testing.T) {
+	dotData := `
+map:
+  f3:
+  f1:
+  f2:
+`
+	var dots Dots
+	assert.Nil(t, yaml.Unmarshal([]byte(dotData), &dots))
+	var froms []string
+	for _, mapping := range dots.FileMappings {
+		froms = append(froms, mapping.From)
+	}
+	assert.Equal(t, []string{"f3", "f1", "f2"}, froms)
+}
+
+func TestPlanFetchOnly(t *testing.T) {
+	st := newTestState(t)
+	st.Targets["/tmp/gone-link"] = StateEntry{Source: "/src", As: "link"}
+	st.Targets["/tmp/gone-clone"] = StateEntry{Source: "https://example.com/repo", As: "git"}
+
+	d := Dots{
+		FileMappings: []FileMapping{{From: "examples/zshrc", To: "out/zshrc", As: "link"}},
+		Resources:    []Resource{{Url: "https://example.com/skipped", To: "out/skipped", As: "file", Skip: true}},
+	}
+	actions := d.Plan(st, Options{FetchOnly: true})
+	assert.Equal(t, []string{ActionForget, ActionSkip}, kinds(actions))
+	assert.Equal(t, "/tmp/gone-clone", actions[0].To)
+}
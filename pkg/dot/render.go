@@ -0,0 +1,75 @@
+package dot
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+ * rendered links: templated mappings that are linked rather than copied,
+ * rendered into dot's cache with the destination a symlink to that
+ */
+
+// the cache holds renderings, which may hold secrets
+const renderDirMode = 0700
+
+func cacheDir() string {
+	if dir := os.Getenv("XDG_CACHE_HOME"); len(dir) > 0 {
+		return filepath.Join(dir, "dot")
+	}
+	return filepath.Join(getHomeDir(), ".cache", "dot")
+}
+
+func renderRoot() string {
+	return filepath.Join(cacheDir(), "rendered")
+}
+
+// renderPath is where the source linked to target is rendered to: under the
+// cache, at target's own path
+func renderPath(target string) string {
+	return filepath.Join(renderRoot(), absPath(target))
+}
+
+func isRendering(path string) bool {
+	return strings.HasPrefix(path, renderRoot()+string(filepath.Separator))
+}
+
+// renders reports whether the mapping's source is a template
+func (m FileMapping) renders() bool {
+	return len(m.With) > 0 || m.Render
+}
+
+func (m FileMapping) isRenderedLink() bool {
+	return m.As == "link" && m.renders()
+}
+
+// rendering is the copy rendering a linked template into the cache
+func (m FileMapping) rendering() FileMapping {
+	r := m
+	r.To = renderPath(m.To)
+	r.As = "copy"
+	r.Relative = false
+	return r
+}
+
+// linkSource is what the mapping's symlink leads to: its source, or where
+// that is rendered to
+func (m FileMapping) linkSource() string {
+	if m.isRenderedLink() {
+		return renderPath(m.To)
+	}
+	return m.From
+}
+
+// render brings the rendering of a linked template up to date
+func (m FileMapping) render() error {
+	r := m.rendering()
+	if r.isUpToDate(nil) {
+		return nil
+	}
+	if err := createPathMode(r.To, renderDirMode); err != nil {
+		return err
+	}
+	return r.doCopy()
+}
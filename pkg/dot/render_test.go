@@ -0,0 +1,96 @@
+package dot
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRenderedLink(t *testing.T, src string, set map[string]interface{}) Dots {
+	d, errs := Dots{
+		Opts:         Opts{Cd: src},
+		Vars:         map[string]interface{}{"email": "me@home.org"},
+		Set:          set,
+		FileMappings: []FileMapping{{From: "gitconfig", With: map[string]string{"Name": "me"}}},
+	}.Transform()
+	assert.Empty(t, errs)
+	assert.Empty(t, d.Validate())
+	return d
+}
+
+func TestRenderedLinks(t *testing.T) {
+	src := t.TempDir()
+	home := t.TempDir()
+	cache := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CACHE_HOME", cache)
+	source := filepath.Join(src, "gitconfig")
+	assert.Nil(t, os.WriteFile(source, []byte("[user]\n  name = {{ .Name }}\n  email = {{ .email }}\n"), 0644))
+
+	st := newTestState(t)
+	d := newTestRenderedLink(t, src, nil)
+	m := d.FileMappings[0]
+	rendered := filepath.Join(cache, "dot/rendered", home, ".gitconfig")
+	assert.Equal(t, rendered, m.linkSource())
+	assert.Equal(t, []string{"render"}, kinds(d.Plan(st, Options{})))
+
+	r := d.Apply(context.Background(), st, Options{})
+	assert.Empty(t, r.Failures)
+	dst, err := os.Readlink(m.To)
+	assert.Nil(t, err)
+	assert.Equal(t, rendered, dst)
+	assert.Equal(t, "[user]\n  name = me\n  email = me@home.org\n", readString(t, m.To))
+	assert.Equal(t, os.FileMode(renderDirMode), fileMode(t, filepath.Dir(rendered)))
+	assert.True(t, st.owns(m.To))
+	assert.Equal(t, StatusOk, m.status(st).Status)
+
+	// rendered again when the source changes
+	assert.Nil(t, os.WriteFile(source, []byte("[user]\n  email = {{ .email }}\n"), 0644))
+	assert.Equal(t, StatusContentDiffers, m.status(st).Status)
+	diff, err := m.diff(st)
+	assert.Nil(t, err)
+	assert.Contains(t, diff, "-  name = me\n")
+	assert.Equal(t, []string{"remove", "render"}, kinds(d.Plan(st, Options{})))
+	r = d.Apply(context.Background(), st, Options{})
+	assert.Empty(t, r.Failures)
+	assert.Equal(t, "[user]\n  email = me@home.org\n", readString(t, m.To))
+
+	// or the variables do
+	d = newTestRenderedLink(t, src, map[string]interface{}{"email": "me@work.com"})
+	assert.False(t, d.FileMappings[0].isUpToDate(st))
+	r = d.Apply(context.Background(), st, Options{})
+	assert.Empty(t, r.Failures)
+	assert.Equal(t, "[user]\n  email = me@work.com\n", readString(t, m.To))
+	assert.Equal(t, []string{"unchanged"}, kinds(d.Plan(st, Options{})))
+
+	// and the rendering goes along with the link
+	r = d.Apply(context.Background(), st, Options{Unlink: true})
+	assert.Empty(t, r.Failures)
+	assert.False(t, pathExists(m.To))
+	assert.False(t, pathExists(rendered))
+}
+
+func TestRenderFlag(t *testing.T) {
+	src := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(src, "zshrc"), []byte("export EMAIL={{ .email }}\n"), 0644))
+
+	d, errs := Dots{
+		Opts: Opts{Cd: src},
+		Vars: map[string]interface{}{"email": "me@home.org"},
+		FileMappings: []FileMapping{
+			{From: "zshrc", To: filepath.Join(src, "out/zshrc"), As: "copy", Render: true},
+			{From: "zshrc", To: filepath.Join(src, "out/zshrc.tree"), As: "tree", Render: true},
+		},
+	}.Transform()
+	assert.Empty(t, errs)
+	content, err := d.FileMappings[0].content()
+	assert.Nil(t, err)
+	assert.Equal(t, "export EMAIL=me@home.org\n", string(content))
+
+	errs = d.Validate()
+	assert.Contains(t, errs, fmt.Errorf("%s/zshrc: templating is only supported in `copy` and `link` modes", src))
+}
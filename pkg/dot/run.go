@@ -0,0 +1,111 @@
+package dot
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+/*
+ * run: setup scripts that run once, on every run, or when the files they
+ * depend on change
+ */
+
+const (
+	RunOnce     = "once"
+	RunAlways   = "always"
+	RunOnChange = "onchange"
+)
+
+type Script struct {
+	Name    string   `yaml:"name"`
+	Command string   `yaml:"script"`
+	When    string   `yaml:"when"`
+	Watch   []string `yaml:"watch"`
+	Os      string   `yaml:"os"`
+
+	ignore *ignorer
+}
+
+func isRunWhen(when string) bool {
+	return when == RunOnce || when == RunAlways || when == RunOnChange
+}
+
+func (s Script) isMatchingOs() bool {
+	return matchesOs(s.Os)
+}
+
+// hash identifies what the script last ran with: its own contents, and for
+// onchange scripts those of the files it watches
+func (s Script) hash() (string, error) {
+	h := sha256.New()
+	io.WriteString(h, s.Command)
+	if s.When != RunOnChange {
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	for _, watched := range s.Watch {
+		err := filepath.WalkDir(watched, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			if s.ignore.ignores(path, false) {
+				return nil
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "\x00%s\x00", path)
+			h.Write(content)
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (s Script) plan(opts Opts, st *State) []Action {
+	if !s.isMatchingOs() {
+		return []Action{{Kind: ActionSkip, From: s.Name, To: s.Name, Reason: "not on " + s.Os}}
+	}
+	if s.When != RunAlways {
+		if hash, err := s.hash(); err == nil && st.Scripts[s.Name] == hash {
+			return []Action{{Kind: ActionUnchanged, From: s.Command, To: s.Name, script: &s}}
+		}
+	}
+	h := &hook{opts.hookDir(), hookEnv("", "", "")}
+	return []Action{{Kind: ActionRun, From: s.Command, To: s.Name, Reason: s.When, script: &s, hook: h}}
+}
+
+// runScript runs the script and, unless it runs always, records what it ran
+// with so that it does not run again until that changes; this is not
+// journaled, as what the script did stays done on rollback
+func (a Action) runScript(ctx context.Context, tx *transaction) error {
+	if err := runShell(ctx, "run "+a.To, a.From, a.hook, tx.o); err != nil {
+		return fmt.Errorf("run %s failed: %v", a.To, err)
+	}
+	if a.script.When == RunAlways {
+		return nil
+	}
+	hash, err := a.script.hash()
+	if err != nil {
+		return err
+	}
+	return tx.st.recordScript(a.To, hash)
+}
+
+func isScript(entry []Action) bool {
+	for _, action := range entry {
+		if action.script != nil {
+			return true
+		}
+	}
+	return false
+}
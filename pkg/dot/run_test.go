@@ -0,0 +1,152 @@
+package dot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanScripts(t *testing.T) {
+	watched := filepath.Join(t.TempDir(), "Brewfile")
+	assert.Nil(t, os.WriteFile(watched, []byte("brew \"git\""), 0644))
+
+	otherOs := "macos"
+	if !matchesOs("linux") {
+		otherOs = "linux"
+	}
+	d := Dots{
+		Scripts: []Script{
+			{Name: "plugins", Command: "true", When: RunOnce},
+			{Name: "motd", Command: "true", When: RunAlways},
+			{Name: "brew", Command: "true", When: RunOnChange, Watch: []string{watched}},
+			{Name: "chsh", Command: "true", When: RunOnce, Os: otherOs},
+		},
+	}
+
+	st := newTestState(t)
+	actions := d.Plan(st, Options{})
+	assert.Equal(t, []string{ActionRun, ActionRun, ActionRun, ActionSkip}, kinds(actions))
+	assert.Equal(t, "run plugins (once)", actions[0].String())
+	assert.Equal(t, "skip chsh: not on "+otherOs, actions[3].String())
+
+	r := d.Apply(context.Background(), st, Options{})
+	assert.Empty(t, r.Failures)
+	assert.Equal(t, 3, r.Applied)
+	assert.Equal(t, 2, len(st.Scripts))
+	assert.Contains(t, st.Scripts, "plugins")
+	assert.Contains(t, st.Scripts, "brew")
+
+	// only always runs again, until a watched file or a script changes
+	assert.Equal(t, []string{ActionUnchanged, ActionRun, ActionUnchanged, ActionSkip}, kinds(d.Plan(st, Options{})))
+	assert.Nil(t, os.WriteFile(watched, []byte("brew \"jq\""), 0644))
+	d.Scripts[0].Command = "true; true"
+	assert.Equal(t, []string{ActionRun, ActionRun, ActionRun, ActionSkip}, kinds(d.Plan(st, Options{})))
+
+	// none when only fetching or removing
+	assert.Empty(t, d.Plan(st, Options{FetchOnly: true}))
+	assert.Empty(t, d.Plan(st, Options{Unlink: true}))
+}
+
+func TestFailingScriptDoesNotAbort(t *testing.T) {
+	to := "out/"
+	assert.Nil(t, createPath(to))
+	defer func() {
+		_ = os.RemoveAll(to)
+	}()
+
+	d := Dots{
+		Opts:         Opts{Cd: "examples"},
+		FileMappings: []FileMapping{{From: "examples/zshrc", To: "out/zshrc", As: "link"}},
+		Scripts: []Script{
+			{Name: "broken", Command: "echo oops; exit 3", When: RunOnce},
+			{Name: "pwd", Command: "pwd", When: RunOnce},
+		},
+	}
+	st := newTestState(t)
+	var logs bytes.Buffer
+	r := d.Apply(context.Background(), st, Options{Logger: log.New(&logs, "", 0)})
+	assert.False(t, r.RolledBack)
+	assert.Equal(t, 2, r.Applied)
+	assert.Equal(t, 1, len(r.Failures))
+	assert.Equal(t, "broken", r.Failures[0].Entry)
+	assert.Equal(t, "run broken failed: exit status 3", r.Failures[0].Err.Error())
+	assert.True(t, isSymlink("out/zshrc"))
+
+	// scripts run in the source tree; failed ones are tried again next time
+	cwd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Equal(t, "run broken: oops\nrun pwd: "+filepath.Join(cwd, "examples")+"\n", logs.String())
+	assert.Equal(t, 1, len(st.Scripts))
+	assert.Contains(t, st.Scripts, "pwd")
+}
+
+func TestRollbackKeepsScriptFailures(t *testing.T) {
+	to := "out/"
+	assert.Nil(t, createPath(to))
+	defer func() {
+		_ = os.RemoveAll(to)
+	}()
+
+	d := Dots{
+		Opts:         Opts{Cd: "examples", Hooks: Hooks{After: "exit 1"}},
+		FileMappings: []FileMapping{{From: "examples/zshrc", To: "out/zshrc", As: "link"}},
+		Scripts:      []Script{{Name: "broken", Command: "exit 3", When: RunOnce}},
+	}
+	r := d.Apply(context.Background(), newTestState(t), Options{})
+	assert.True(t, r.RolledBack)
+	assert.Equal(t, 2, len(r.Failures))
+	assert.Equal(t, "broken", r.Failures[0].Entry)
+	assert.Equal(t, "hook after `exit 1` failed: exit status 1", r.Failures[1].Err.Error())
+	assert.False(t, pathExists("out/zshrc"))
+}
+
+func TestDecodeScripts(t *testing.T) {
+	dots, err := Decode([]byte(`
+run:
+  - name: zinit
+    script: bash -c "$(curl -fsSL https://git.io/zinit-install)"
+  - name: brew
+    script: brew bundle
+    when: onchange
+    watch: [Brewfile]
+    os: macos
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, []Script{
+		{Name: "zinit", Command: `bash -c "$(curl -fsSL https://git.io/zinit-install)"`},
+		{Name: "brew", Command: "brew bundle", When: RunOnChange, Watch: []string{"Brewfile"}, Os: "macos"},
+	}, dots.Scripts)
+
+	transformed, errs := dots.Transform()
+	assert.Empty(t, errs)
+	cwd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Equal(t, RunOnce, transformed.Scripts[0].When)
+	assert.Equal(t, []string{cwd + "/Brewfile"}, transformed.Scripts[1].Watch)
+}
+
+func TestValidateScripts(t *testing.T) {
+	d := Dots{
+		Scripts: []Script{
+			{Command: "true", When: RunOnce},
+			{Name: "a", Command: "true", When: RunOnce},
+			{Name: "a", When: "sometimes"},
+			{Name: "b", Command: "true", When: RunOnChange},
+			{Name: "c", Command: "true", When: RunOnChange, Watch: []string{"examples/nothere"}},
+		},
+	}
+	errs := d.Validate()
+	assert.Equal(t, 6, len(errs))
+	assert.Contains(t, errs, fmt.Errorf("run: script name (`name`) cannot be empty"))
+	assert.Contains(t, errs, fmt.Errorf("a: duplicate script name"))
+	assert.Contains(t, errs, fmt.Errorf("a: script (`script`) cannot be empty"))
+	assert.Contains(t, errs, fmt.Errorf("a: unknown `when` value `sometimes`"))
+	assert.Contains(t, errs, fmt.Errorf("b: onchange scripts need files to `watch`"))
+	assert.Contains(t, errs, fmt.Errorf("c: examples/nothere: path does not exist"))
+}
@@ -0,0 +1,181 @@
+package dot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+/*
+ * state: what dot created on previous runs, so it knows what it owns
+ */
+
+const stateFile = "state.json"
+
+type StateEntry struct {
+	// Dots is the dots file the target was created for
+	Dots   string      `json:"dots,omitempty"`
+	Source string      `json:"source"`
+	As     string      `json:"as"`
+	Mode   os.FileMode `json:"mode,omitempty"`
+	Hash   string      `json:"hash,omitempty"`
+	Files  []string    `json:"files,omitempty"`
+	Dirs   []string    `json:"dirs,omitempty"`
+}
+
+type State struct {
+	path    string
+	Targets map[string]StateEntry `json:"targets"`
+	Scripts map[string]string     `json:"scripts,omitempty"`
+}
+
+func StatePath() string {
+	return filepath.Join(stateDir(), stateFile)
+}
+
+func LoadState(path string) (*State, error) {
+	st := &State{path: path, Targets: map[string]StateEntry{}, Scripts: map[string]string{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if st.Targets == nil {
+		st.Targets = map[string]StateEntry{}
+	}
+	if st.Scripts == nil {
+		st.Scripts = map[string]string{}
+	}
+	return st, nil
+}
+
+func (st *State) save() error {
+	if err := createPath(st.path); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(st.path, data, 0600)
+}
+
+func (st *State) record(target string, entry StateEntry) error {
+	target = absPath(target)
+	switch entry.As {
+	case "copy", "file", "hardlink":
+		hash, err := hashPath(target)
+		if err != nil {
+			return err
+		}
+		fileInfo, err := os.Stat(target)
+		if err != nil {
+			return err
+		}
+		entry.Hash = hash
+		entry.Mode = fileInfo.Mode().Perm()
+	}
+	st.Targets[target] = entry
+	return st.save()
+}
+
+func (st *State) forget(target string) error {
+	target = absPath(target)
+	if _, ok := st.Targets[target]; !ok {
+		return nil
+	}
+	delete(st.Targets, target)
+	return st.save()
+}
+
+// recordScript notes the hash a script last ran with
+func (st *State) recordScript(name, hash string) error {
+	st.Scripts[name] = hash
+	return st.save()
+}
+
+// owns reports whether target is recorded in the state and is still in the
+// shape dot left it in
+func (st *State) owns(target string) bool {
+	entry, ok := st.Targets[absPath(target)]
+	if !ok {
+		return false
+	}
+	switch entry.As {
+	case "link":
+		return isLinkTo(target, entry.Source)
+	case "hardlink":
+		// or left behind by the source being replaced, as editors do
+		if isSameFile(target, entry.Source) {
+			return true
+		}
+		hash, err := hashPath(target)
+		return err == nil && hash == entry.Hash
+	case "copy", "file":
+		if isSymlink(target) {
+			return false
+		}
+		hash, err := hashPath(target)
+		return err == nil && hash == entry.Hash
+	case "git":
+		return isGitClone(target, entry.Source)
+	case "tree":
+		// only ever unfolded, which leaves alone whatever is not dot's
+		return isRealDirectory(target)
+	}
+	return false
+}
+
+// stale lists the targets recorded in the state for the dots file that are
+// no longer part of it; those of other dots files are theirs to prune
+func (st *State) stale(dots Dots) []string {
+	current := map[string]bool{}
+	for _, mapping := range dots.FileMappings {
+		current[absPath(mapping.To)] = true
+	}
+	for _, resource := range dots.Resources {
+		current[absPath(resource.destination())] = true
+	}
+
+	var targets []string
+	for target, entry := range st.Targets {
+		if entry.Dots == dots.File && !current[target] {
+			targets = append(targets, target)
+		}
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// hashPath hashes a file, or everything in a directory
+func hashPath(path string) (string, error) {
+	if isDirectory(path) {
+		return hashTree(path)
+	}
+	return hashFile(path)
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,167 @@
+package dot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestState(t *testing.T) *State {
+	st, err := LoadState(filepath.Join(t.TempDir(), stateFile))
+	assert.Nil(t, err)
+	return st
+}
+
+func TestStatePath(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", "/state")
+	assert.Equal(t, "/state/dot/state.json", StatePath())
+}
+
+func TestStateRecordAndLoad(t *testing.T) {
+	to := "out/"
+	assert.Nil(t, createPath(to))
+	defer func() {
+		_ = os.RemoveAll(to)
+	}()
+
+	st := newTestState(t)
+
+	link := FileMapping{From: "examples/zshrc", To: "out/zshrc", As: "link"}
+	assert.Nil(t, link.doLink())
+	assert.Nil(t, st.record(link.To, StateEntry{Source: link.From, As: link.As}))
+
+	cp := FileMapping{From: "examples/gitconfig", To: "out/gitconfig", As: "copy"}
+	assert.Nil(t, cp.doCopy())
+	assert.Nil(t, st.record(cp.To, StateEntry{Source: cp.From, As: cp.As}))
+
+	// persisted
+	loaded, err := LoadState(st.path)
+	assert.Nil(t, err)
+	assert.Equal(t, st.Targets, loaded.Targets)
+	entry := loaded.Targets[absPath("out/gitconfig")]
+	assert.Equal(t, "copy", entry.As)
+	assert.NotEmpty(t, entry.Hash)
+	assert.NotZero(t, entry.Mode)
+
+	assert.True(t, st.owns("out/zshrc"))
+	assert.True(t, st.owns("out/gitconfig"))
+
+	// modified after dot created it
+	assert.Nil(t, os.WriteFile("out/gitconfig", []byte("changed"), 0644))
+	assert.False(t, st.owns("out/gitconfig"))
+
+	assert.Nil(t, st.forget("out/zshrc"))
+	assert.False(t, st.owns("out/zshrc"))
+	loaded, err = LoadState(st.path)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(loaded.Targets))
+}
+
+func TestPrune(t *testing.T) {
+	to := "out/"
+	assert.Nil(t, createPath(to))
+	defer func() {
+		_ = os.RemoveAll(to)
+	}()
+
+	st := newTestState(t)
+	for _, m := range []FileMapping{
+		{From: "examples/zshrc", To: "out/zshrc", As: "link"},
+		{From: "examples/gitconfig", To: "out/gitconfig", As: "link"},
+		{From: "examples/gitconfig", To: "out/modified", As: "copy"},
+		{From: "examples/gitconfig", To: "out/gone", As: "copy"},
+	} {
+		_, err := m.domap(st, Options{})
+		assert.Nil(t, err)
+		assert.Nil(t, st.record(m.To, StateEntry{Source: m.From, As: m.As}))
+	}
+	assert.Nil(t, os.WriteFile("out/modified", []byte("changed"), 0644))
+	assert.Nil(t, os.Remove("out/gone"))
+
+	// only zshrc is left in the dots file
+	d := Dots{
+		Opts: Opts{Backup: t.TempDir()},
+		FileMappings: []FileMapping{
+			{From: "examples/zshrc", To: "out/zshrc", As: "link"},
+		},
+	}
+	actions := d.Plan(st, Options{})
+	assert.Equal(t, []string{ActionPrune, ActionForget, ActionForget, ActionUnchanged}, kinds(actions))
+	assert.Equal(t, absPath("out/gitconfig"), actions[0].To)
+
+	assert.Empty(t, d.Apply(context.Background(), st, Options{}).Failures)
+	assert.False(t, pathExists("out/gitconfig"))
+	assert.True(t, pathExists("out/modified"))
+	assert.True(t, isSymlink("out/zshrc"))
+	assert.Equal(t, []string{absPath("out/zshrc")}, st.stale(Dots{}))
+}
+
+func TestRmOnlyRemovesOwnedTargets(t *testing.T) {
+	to := "out/"
+	assert.Nil(t, createPath(to))
+	defer func() {
+		_ = os.RemoveAll(to)
+	}()
+
+	st := newTestState(t)
+	m := FileMapping{From: "examples/gitconfig", To: "out/gitconfig", As: "copy"}
+	assert.Nil(t, m.doCopy())
+	assert.Nil(t, st.record(m.To, StateEntry{Source: m.From, As: m.As}))
+	assert.Equal(t, []string{ActionRemove}, kinds(m.plan(Opts{}, st, Options{Unlink: true})))
+
+	assert.Nil(t, os.WriteFile("out/gitconfig", []byte("changed"), 0644))
+	assert.Equal(t, []string{ActionSkip}, kinds(m.plan(Opts{}, st, Options{Unlink: true})))
+}
+
+func TestPruneLeavesOtherDotsFiles(t *testing.T) {
+	to := "out/"
+	assert.Nil(t, createPath(to))
+	defer func() {
+		_ = os.RemoveAll(to)
+	}()
+
+	st := newTestState(t)
+	work := Dots{
+		Opts:         Opts{Backup: t.TempDir()},
+		FileMappings: []FileMapping{{From: "examples/gitconfig", To: "out/gitconfig", As: "link"}},
+		File:         absPath("work.yml"),
+	}
+	home := Dots{
+		Opts:         Opts{Backup: t.TempDir()},
+		FileMappings: []FileMapping{{From: "examples/zshrc", To: "out/zshrc", As: "link"}},
+		File:         absPath("home.yml"),
+	}
+	assert.Empty(t, work.Apply(context.Background(), st, Options{}).Failures)
+	assert.Empty(t, home.Apply(context.Background(), st, Options{}).Failures)
+	assert.Equal(t, absPath("work.yml"), st.Targets[absPath("out/gitconfig")].Dots)
+
+	// neither prunes what the other created
+	assert.Equal(t, []string{ActionUnchanged}, kinds(work.Plan(st, Options{})))
+	assert.Equal(t, []string{ActionUnchanged}, kinds(home.Plan(st, Options{})))
+	assert.True(t, isSymlink("out/gitconfig"))
+	assert.True(t, isSymlink("out/zshrc"))
+
+	// but each still prunes its own
+	work.FileMappings = nil
+	assert.Equal(t, []string{ActionPrune}, kinds(work.Plan(st, Options{})))
+}
+
+func TestUnchangedClaimsUnownedEntries(t *testing.T) {
+	to := "out/"
+	assert.Nil(t, createPath(to))
+	defer func() {
+		_ = os.RemoveAll(to)
+	}()
+
+	st := newTestState(t)
+	m := FileMapping{From: "examples/zshrc", To: "out/zshrc", As: "link"}
+	assert.Nil(t, m.doLink())
+	assert.Nil(t, st.record(m.To, StateEntry{Source: m.From, As: m.As}))
+
+	d := Dots{Opts: Opts{Backup: t.TempDir()}, FileMappings: []FileMapping{m}, File: absPath("dot.yml")}
+	assert.Empty(t, d.Apply(context.Background(), st, Options{}).Failures)
+	assert.Equal(t, absPath("dot.yml"), st.Targets[absPath("out/zshrc")].Dots)
+}
@@ -0,0 +1,214 @@
+package dot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+/*
+ * status: how far the machine has drifted from the dots file
+ */
+
+const (
+	StatusOk              = "ok"
+	StatusMissing         = "missing"
+	StatusWrongLinkTarget = "wrong-link-target"
+	StatusNotSymlink      = "not-a-symlink"
+	StatusNotHardlink     = "not-a-hardlink"
+	StatusLinksMissing    = "links-missing"
+	StatusContentDiffers  = "copy-content-differs"
+	StatusSkippedOs       = "skipped-for-os"
+	StatusSkipped         = "skipped"
+	StatusPresent         = "present"
+	StatusNotClone        = "not-a-clone"
+	StatusGitDirty        = "git-dirty"
+	StatusBehindRemote    = "behind-remote"
+)
+
+type Status struct {
+	Status string
+	From   string
+	To     string
+	Detail string
+}
+
+func (s Status) String() string {
+	str := fmt.Sprintf("%-20s %s", s.Status, s.To)
+	if len(s.Detail) > 0 {
+		str += " (" + s.Detail + ")"
+	}
+	return str
+}
+
+func (s Status) IsDrift() bool {
+	switch s.Status {
+	case StatusOk, StatusSkippedOs, StatusSkipped, StatusPresent:
+		return false
+	}
+	return true
+}
+
+func (m FileMapping) status(st *State) Status {
+	s := Status{From: m.From, To: m.To}
+	if !m.isMatchingOs() {
+		s.Status = StatusSkippedOs
+		return s
+	}
+	if !pathExists(m.To) {
+		s.Status = StatusMissing
+		return s
+	}
+
+	switch m.As {
+	case "link":
+		dst, err := os.Readlink(m.To)
+		if err != nil {
+			s.Status = StatusNotSymlink
+		} else if !m.isLinked() {
+			s.Status = StatusWrongLinkTarget
+			s.Detail = "points to " + dst
+		} else if m.isRenderedLink() && !m.rendering().isUpToDate(st) {
+			s.Status = StatusContentDiffers
+			s.Detail = "rendering out of date"
+		} else {
+			s.Status = StatusOk
+		}
+	case "hardlink":
+		s.Status = StatusOk
+		if !isSameFile(m.To, m.From) {
+			s.Status = StatusNotHardlink
+		}
+	case "copy":
+		s.Status = StatusOk
+		if !m.isUpToDate(st) {
+			s.Status = StatusContentDiffers
+			if isSymlink(m.To) {
+				s.Detail = "is a symlink"
+			} else if isDirectory(m.From) {
+				if changes, err := m.treeChanges(); err != nil {
+					s.Detail = err.Error()
+				} else {
+					s.Detail = "differs in " + strings.Join(changes, ", ")
+				}
+			} else if want, err := m.content(); err != nil {
+				s.Detail = err.Error()
+			} else if got, err := os.ReadFile(m.To); err != nil {
+				s.Detail = err.Error()
+			} else if bytes.Equal(want, got) {
+				s.Detail = "mode differs"
+			}
+		}
+	case "tree":
+		s.Status = StatusOk
+		if !isRealDirectory(m.To) {
+			s.Status = StatusLinksMissing
+			s.Detail = "not a directory"
+		} else if unlinked, err := m.unlinkedFiles(); err != nil {
+			s.Status = StatusLinksMissing
+			s.Detail = err.Error()
+		} else if len(unlinked) > 0 {
+			s.Status = StatusLinksMissing
+			s.Detail = "missing " + strings.Join(unlinked, ", ")
+		}
+	}
+	return s
+}
+
+func (r Resource) status(ctx context.Context) Status {
+	s := Status{From: r.Url, To: r.destination()}
+	if r.Skip {
+		s.Status = StatusSkipped
+		return s
+	}
+	if !pathExists(s.To) {
+		s.Status = StatusMissing
+		return s
+	}
+	s.Status = StatusPresent
+	if r.As != "git" {
+		return s
+	}
+
+	if !isGitClone(r.To, r.Url) {
+		s.Status = StatusNotClone
+		return s
+	}
+	if isDirtyGitClone(r.To) {
+		s.Status = StatusGitDirty
+		return s
+	}
+	behind, err := isBehindRemote(ctx, r.To)
+	if err != nil {
+		s.Detail = "could not check remote: " + err.Error()
+	} else if behind {
+		s.Status = StatusBehindRemote
+	}
+	return s
+}
+
+// isBehindRemote reports whether the remote has commits for the checked
+// out branch that the clone does not; it lists the remote's refs without
+// fetching anything
+func isBehindRemote(ctx context.Context, dir string) (bool, error) {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return false, err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return false, err
+	}
+	remote, err := repo.Remote("origin")
+	if err != nil {
+		return false, err
+	}
+	refs, err := remote.ListContext(ctx, &git.ListOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	refName := head.Name()
+	if !refName.IsBranch() {
+		refName = plumbing.HEAD
+	}
+	for _, ref := range refs {
+		if ref.Name() != refName {
+			continue
+		}
+		if ref.Hash() == head.Hash() {
+			return false, nil
+		}
+		remoteCommit, err := repo.CommitObject(ref.Hash())
+		if err == plumbing.ErrObjectNotFound {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		headCommit, err := repo.CommitObject(head.Hash())
+		if err != nil {
+			return false, err
+		}
+		return headCommit.IsAncestor(remoteCommit)
+	}
+	return false, nil
+}
+
+// Status reports, without changing anything, how each mapping and resource
+// differs from what applying the dots file would produce
+func (dots Dots) Status(ctx context.Context, st *State) []Status {
+	var statuses []Status
+	for _, mapping := range dots.FileMappings {
+		statuses = append(statuses, mapping.status(st))
+	}
+	for _, resource := range dots.Resources {
+		statuses = append(statuses, resource.status(ctx))
+	}
+	return statuses
+}
@@ -0,0 +1,104 @@
+package dot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+)
+
+func commitFile(t *testing.T, dir, file, content string) {
+	repo, err := git.PlainOpen(dir)
+	assert.Nil(t, err)
+	worktree, err := repo.Worktree()
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, file), []byte(content), 0644))
+	_, err = worktree.Add(file)
+	assert.Nil(t, err)
+	_, err = worktree.Commit("update "+file, &git.CommitOptions{
+		Author: &object.Signature{Name: "dot", Email: "dot@example.com", When: time.Now()},
+	})
+	assert.Nil(t, err)
+}
+
+// newTestRemote creates a local git repository with a single commit
+func newTestRemote(t *testing.T) string {
+	dir := t.TempDir()
+	_, err := git.PlainInit(dir, false)
+	assert.Nil(t, err)
+	commitFile(t, dir, "README", "hello")
+	return dir
+}
+
+func TestMappingStatus(t *testing.T) {
+	to := "out/"
+	assert.Nil(t, createPath(to))
+	defer func() {
+		_ = os.RemoveAll(to)
+	}()
+	st := newTestState(t)
+
+	otherOs := "macos"
+	if !(FileMapping{Os: "linux"}).isMatchingOs() {
+		otherOs = "linux"
+	}
+
+	link := FileMapping{From: "examples/zshrc", To: "out/zshrc", As: "link"}
+	cp := FileMapping{From: "examples/gitconfig", To: "out/gitconfig", As: "copy"}
+
+	assert.Equal(t, StatusSkippedOs, FileMapping{From: "examples/zshrc", To: "out/zshrc", As: "link", Os: otherOs}.status(st).Status)
+	assert.Equal(t, StatusMissing, link.status(st).Status)
+	assert.Equal(t, StatusMissing, cp.status(st).Status)
+
+	assert.Nil(t, link.doLink())
+	assert.Nil(t, cp.doCopy())
+	assert.Equal(t, StatusOk, link.status(st).Status)
+	assert.Equal(t, StatusOk, cp.status(st).Status)
+
+	wrong := FileMapping{From: "examples/gitconfig", To: "out/zshrc", As: "link"}
+	s := wrong.status(st)
+	assert.Equal(t, StatusWrongLinkTarget, s.Status)
+	assert.Equal(t, "points to examples/zshrc", s.Detail)
+	assert.True(t, s.IsDrift())
+
+	notLink := FileMapping{From: "examples/gitconfig", To: "out/gitconfig", As: "link"}
+	assert.Equal(t, StatusNotSymlink, notLink.status(st).Status)
+
+	assert.Nil(t, os.WriteFile("out/gitconfig", []byte("changed"), 0644))
+	assert.Equal(t, StatusContentDiffers, cp.status(st).Status)
+}
+
+func TestResourceStatus(t *testing.T) {
+	remote := newTestRemote(t)
+	to := filepath.Join(t.TempDir(), "clone")
+
+	r := Resource{Url: remote, To: to, As: "git"}
+	assert.Equal(t, StatusMissing, r.status(context.Background()).Status)
+	assert.Equal(t, StatusSkipped, Resource{Url: remote, To: to, As: "git", Skip: true}.status(context.Background()).Status)
+
+	assert.Nil(t, fetchGitResource(context.Background(), r, Options{}))
+	s := r.status(context.Background())
+	assert.Equal(t, StatusPresent, s.Status)
+	assert.False(t, s.IsDrift())
+
+	// new commits upstream
+	commitFile(t, remote, "README", "hello again")
+	assert.Equal(t, StatusBehindRemote, r.status(context.Background()).Status)
+
+	// local changes
+	assert.Nil(t, os.WriteFile(filepath.Join(to, "README"), []byte("changed"), 0644))
+	assert.Equal(t, StatusGitDirty, r.status(context.Background()).Status)
+
+	// cloned from somewhere else
+	r.Url = "https://example.com/other"
+	assert.Equal(t, StatusNotClone, r.status(context.Background()).Status)
+
+	// plain files
+	f := Resource{Url: "https://example.com/README", To: filepath.Join(to, "README"), As: "file"}
+	assert.Equal(t, StatusPresent, f.status(context.Background()).Status)
+}
@@ -0,0 +1,125 @@
+package dot
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+ * template functions: what `with` values and rendered files can call,
+ * beyond what text/template has built in
+ */
+
+// TemplateFunc documents a function templates can call
+type TemplateFunc struct {
+	Name  string
+	Usage string
+	Doc   string
+}
+
+// TemplateFuncs lists the functions templates can call, in the order they
+// are documented in
+var TemplateFuncs = []TemplateFunc{
+	{"env", "env NAME", "the value of an environment variable, empty if unset"},
+	{"default", "default DEFAULT VALUE", "VALUE, or DEFAULT when it is empty or missing"},
+	{"lookPath", "lookPath NAME", "the path of a command on PATH, empty if there is none"},
+	{"hasCommand", "hasCommand NAME", "whether a command is on PATH"},
+	{"joinPath", "joinPath ELEM...", "the elements joined into a path"},
+	{"include", "include FILE", "the contents of a file, relative to the source tree (opt.cd)"},
+	{"lower", "lower STRING", "the string in lower case"},
+	{"upper", "upper STRING", "the string in upper case"},
+	{"trim", "trim STRING", "the string without leading and trailing white space"},
+	{"replace", "replace OLD NEW STRING", "the string with every OLD replaced by NEW"},
+	{"toJson", "toJson VALUE", "the value encoded as JSON"},
+	{"toYaml", "toYaml VALUE", "the value encoded as YAML"},
+	{"quote", "quote VALUE", "the value as a double quoted, escaped string"},
+}
+
+// templater renders `with` values, and the files of templated mappings
+type templater struct {
+	// the source tree, which files are included from
+	root string
+	vars map[string]interface{}
+}
+
+func (opts Opts) newTemplater(vars map[string]interface{}) *templater {
+	return &templater{root: filepath.Clean(opts.sourcePath(".")), vars: vars}
+}
+
+// render executes text as a template, seeing the variables and the
+// mapping's `with` values; it's nil-safe, without variables then, and
+// including files from the current directory
+func (tp *templater) render(text string, with map[string]string) (string, error) {
+	if tp == nil {
+		tp = &templater{}
+	}
+	return evalTemplateString(text, templateData(tp.vars, with), tp.root)
+}
+
+// templateFuncs implements TemplateFuncs; files are included from root
+func templateFuncs(root string) template.FuncMap {
+	return template.FuncMap{
+		"env":     os.Getenv,
+		"default": defaultValue,
+		"lookPath": func(name string) string {
+			p, _ := exec.LookPath(name)
+			return p
+		},
+		"hasCommand": func(name string) bool {
+			_, err := exec.LookPath(name)
+			return err == nil
+		},
+		"joinPath": filepath.Join,
+		"include": func(file string) (string, error) {
+			content, err := os.ReadFile(filepath.Join(root, file))
+			return string(content), err
+		},
+		"lower": strings.ToLower,
+		"upper": strings.ToUpper,
+		"trim":  strings.TrimSpace,
+		"replace": func(from, to, s string) string {
+			return strings.ReplaceAll(s, from, to)
+		},
+		"toJson": func(v interface{}) (string, error) {
+			out, err := json.Marshal(v)
+			return string(out), err
+		},
+		"toYaml": func(v interface{}) (string, error) {
+			out, err := yaml.Marshal(v)
+			return strings.TrimSuffix(string(out), "\n"), err
+		},
+		"quote": func(v interface{}) string {
+			return strconv.Quote(fmt.Sprint(v))
+		},
+	}
+}
+
+// defaultValue takes value as variadic, so that a missing one, which
+// templates pass as no value at all, falls back too
+func defaultValue(def interface{}, value ...interface{}) interface{} {
+	if len(value) == 0 || isEmpty(value[0]) {
+		return def
+	}
+	return value[0]
+}
+
+func isEmpty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Array, reflect.String:
+		return rv.Len() == 0
+	}
+	return rv.IsZero()
+}
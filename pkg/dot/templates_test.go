@@ -0,0 +1,68 @@
+package dot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateFuncsDocumented(t *testing.T) {
+	funcs := templateFuncs("")
+	assert.Equal(t, len(funcs), len(TemplateFuncs))
+	for _, f := range TemplateFuncs {
+		assert.Contains(t, funcs, f.Name)
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	root := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(root, "aliases"), []byte("alias g=git\n"), 0644))
+	t.Setenv("DOT_TEST_EDITOR", "nvim")
+
+	env := map[string]interface{}{
+		"Name":   "  Me  ",
+		"Empty":  "",
+		"Emails": []string{"me@home.org", "me@work.com"},
+	}
+	for templ, want := range map[string]string{
+		`{{ env "DOT_TEST_EDITOR" }}`:                  "nvim",
+		`{{ env "DOT_TEST_NOTHERE" }}`:                 "",
+		`{{ .Empty | default "vi" }}`:                  "vi",
+		`{{ .Missing | default "vi" }}`:                "vi",
+		`{{ .Name | default "vi" }}`:                   "  Me  ",
+		`{{ hasCommand "sh" }} {{ hasCommand "nox" }}`: "true false",
+		`{{ lookPath "nox" }}`:                         "",
+		`{{ joinPath "/opt" "homebrew" "bin" }}`:       "/opt/homebrew/bin",
+		`{{ include "aliases" }}`:                      "alias g=git\n",
+		`{{ .Name | trim | lower }}`:                   "me",
+		`{{ .Name | trim | upper }}`:                   "ME",
+		`{{ "a-b-c" | replace "-" "." }}`:              "a.b.c",
+		`{{ toJson .Emails }}`:                         `["me@home.org","me@work.com"]`,
+		`{{ toYaml .Emails }}`:                         "- me@home.org\n- me@work.com",
+		`{{ quote "say \"hi\"" }}`:                     `"say \"hi\""`,
+	} {
+		got, err := evalTemplateString(templ, env, root)
+		assert.Nil(t, err, templ)
+		assert.Equal(t, want, got, templ)
+	}
+
+	_, err := evalTemplateString(`{{ include "nothere" }}`, env, root)
+	assert.NotNil(t, err)
+}
+
+func TestRenderIncludes(t *testing.T) {
+	root := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(root, "common"), []byte("set -o vi\n"), 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(root, "zshrc"), []byte(`{{ include "common" }}export EDITOR={{ .Editor }}`+"\n"), 0644))
+
+	d, errs := Dots{
+		Opts:         Opts{Cd: root},
+		FileMappings: []FileMapping{{From: "zshrc", As: "copy", With: map[string]string{"Editor": `{{ include "common" | trim | replace "set -o " "" }}`}}},
+	}.Transform()
+	assert.Empty(t, errs)
+	content, err := d.FileMappings[0].content()
+	assert.Nil(t, err)
+	assert.Equal(t, "set -o vi\nexport EDITOR=vi\n", string(content))
+}
@@ -0,0 +1,356 @@
+package dot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+ * trees: directories mapped as copies, file by file
+ */
+
+// treeEntry is a file, directory or symlink in a copied directory, as it
+// should end up in the target
+type treeEntry struct {
+	rel     string
+	mode    fs.FileMode
+	link    string
+	content []byte
+}
+
+// isText tells text from binary files the way git does: by looking for a
+// NUL byte near the start
+func isText(content []byte) bool {
+	if len(content) > 8000 {
+		content = content[:8000]
+	}
+	return bytes.IndexByte(content, 0) < 0
+}
+
+// treeContent is what a file in a copied directory becomes: rendered with
+// the mapping's `with` values when it is text, as is otherwise
+func (m FileMapping) treeContent(path string) ([]byte, error) {
+	in, err := os.ReadFile(path)
+	if err != nil || !m.renders() || !isText(in) {
+		return in, err
+	}
+	out, err := m.tmpl.render(string(in), m.With)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return []byte(out), nil
+}
+
+// sourceTree lists what the mapping's source directory copies into its
+// target, parents first, starting with the directory itself
+func (m FileMapping) sourceTree() ([]treeEntry, error) {
+	root, err := filepath.EvalSymlinks(m.From)
+	if err != nil {
+		return nil, err
+	}
+	var entries []treeEntry
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		if rel != "." && m.ignore.ignores(filepath.Join(m.From, rel), d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		entry := treeEntry{rel: rel, mode: info.Mode()}
+		if info.Mode().IsRegular() && m.Mode != 0 {
+			entry.mode = os.FileMode(m.Mode)
+		} else if info.IsDir() && m.DirMode != 0 {
+			entry.mode = fs.ModeDir | os.FileMode(m.DirMode)
+		}
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			entry.link, err = os.Readlink(path)
+		case info.Mode().IsRegular():
+			entry.content, err = m.treeContent(path)
+		}
+		entries = append(entries, entry)
+		return err
+	})
+	return entries, err
+}
+
+// matches reports whether dst is already what the entry describes
+func (e treeEntry) matches(dst string) bool {
+	info, err := os.Lstat(dst)
+	if err != nil || info.Mode().Type() != e.mode.Type() {
+		return false
+	}
+	switch {
+	case e.mode&fs.ModeSymlink != 0:
+		link, err := os.Readlink(dst)
+		return err == nil && link == e.link
+	case e.mode.IsRegular():
+		content, err := os.ReadFile(dst)
+		if err != nil || !bytes.Equal(content, e.content) {
+			return false
+		}
+	}
+	return info.Mode().Perm() == e.mode.Perm()
+}
+
+// extraFiles lists the files in the mapping's target, relative to it, that
+// are not among the wanted ones; a directory that is not wanted is listed
+// alone, without its contents. Ignored files are left out: they are left
+// alone in the target
+func (m FileMapping) extraFiles(want map[string]bool) ([]string, error) {
+	var extra []string
+	err := filepath.WalkDir(m.To, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(m.To, path)
+		if want[rel] || m.ignore.ignores(filepath.Join(m.From, rel), d.IsDir()) {
+			return nil
+		}
+		extra = append(extra, rel)
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return extra, err
+}
+
+func wanted(entries []treeEntry) map[string]bool {
+	want := map[string]bool{}
+	for _, entry := range entries {
+		want[entry.rel] = true
+	}
+	return want
+}
+
+// copyTree copies the mapping's source directory into its target, leaving
+// out nothing and nothing more: files in the target that are not in the
+// source are removed
+func (m FileMapping) copyTree() error {
+	entries, err := m.sourceTree()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		dst := filepath.Join(m.To, entry.rel)
+		if entry.matches(dst) && !entry.mode.IsDir() {
+			continue
+		}
+		if info, err := os.Lstat(dst); err == nil && (info.Mode().Type() != entry.mode.Type() || !entry.mode.IsDir()) {
+			if err := os.RemoveAll(dst); err != nil {
+				return err
+			}
+		}
+		switch {
+		case entry.mode.IsDir():
+			// writable until its contents are in place
+			err = os.MkdirAll(dst, 0700)
+			if err == nil {
+				err = os.Chmod(dst, entry.mode.Perm()|0700)
+			}
+		case entry.mode&fs.ModeSymlink != 0:
+			err = os.Symlink(entry.link, dst)
+		default:
+			err = os.WriteFile(dst, entry.content, entry.mode.Perm())
+			if err == nil {
+				err = os.Chmod(dst, entry.mode.Perm())
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	extra, err := m.extraFiles(wanted(entries))
+	if err != nil {
+		return err
+	}
+	for _, rel := range extra {
+		if err := os.RemoveAll(filepath.Join(m.To, rel)); err != nil {
+			return err
+		}
+	}
+
+	// directories get their modes last, innermost first
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].mode.IsDir() {
+			if err := os.Chmod(filepath.Join(m.To, entries[i].rel), entries[i].mode.Perm()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// syncsInPlace reports whether the mapping copies a directory over the copy
+// dot made of it before; copyTree then brings that in line file by file,
+// rather than it being replaced, so that what an application wrote there
+// and is ignored stays
+func (m FileMapping) syncsInPlace(st *State) bool {
+	entry, ok := st.Targets[absPath(m.To)]
+	return ok && entry.As == "copy" && isDirectory(m.From) && isRealDirectory(m.To)
+}
+
+// cloneTree copies the directory src to dst exactly as it is: every file,
+// mode and symlink
+func cloneTree(src, dst string) error {
+	var dirs []string
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		to := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			// writable until its contents are in place
+			dirs = append(dirs, rel)
+			return os.Mkdir(to, 0700)
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, to)
+		default:
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(to, content, info.Mode().Perm()); err != nil {
+				return err
+			}
+			return os.Chmod(to, info.Mode().Perm())
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		info, err := os.Stat(filepath.Join(src, dirs[i]))
+		if err != nil {
+			return err
+		}
+		if err := os.Chmod(filepath.Join(dst, dirs[i]), info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// treeChanges lists, relative to the mapping's target, the files copying
+// the directory would create, change or remove
+func (m FileMapping) treeChanges() ([]string, error) {
+	entries, err := m.sourceTree()
+	if err != nil {
+		return nil, err
+	}
+	var changes []string
+	for _, entry := range entries {
+		if !entry.matches(filepath.Join(m.To, entry.rel)) {
+			changes = append(changes, entry.rel)
+		}
+	}
+	if !isDirectory(m.To) || isSymlink(m.To) {
+		return changes, nil
+	}
+	extra, err := m.extraFiles(wanted(entries))
+	return append(changes, extra...), err
+}
+
+func (m FileMapping) isTreeUpToDate() bool {
+	changes, err := m.treeChanges()
+	return err == nil && len(changes) == 0
+}
+
+// treeDiff is the diff of every file copying the directory would change
+func (m FileMapping) treeDiff() (string, error) {
+	entries, err := m.sourceTree()
+	if err != nil {
+		return "", err
+	}
+	byRel := map[string]treeEntry{}
+	for _, entry := range entries {
+		byRel[entry.rel] = entry
+	}
+	changes, err := m.treeChanges()
+	if err != nil {
+		return "", err
+	}
+
+	var diff strings.Builder
+	for _, rel := range changes {
+		dst := filepath.Join(m.To, rel)
+		entry, ok := byRel[rel]
+		switch {
+		case !ok:
+			fmt.Fprintf(&diff, "--- %s (%s)\n+++ %s (removed)\n", dst, describeTarget(dst), dst)
+		case entry.mode.IsRegular():
+			d, err := copyDiff(dst, filepath.Join(m.From, rel), entry.content)
+			if err != nil {
+				return "", err
+			}
+			diff.WriteString(d)
+		case entry.mode.IsDir():
+			fmt.Fprintf(&diff, "--- %s (%s)\n+++ %s (directory)\n", dst, describeTarget(dst), dst)
+		default:
+			fmt.Fprintf(&diff, "--- %s (%s)\n+++ %s (symlink to %s)\n", dst, describeTarget(dst), dst, entry.link)
+		}
+	}
+	return diff.String(), nil
+}
+
+// hashTree hashes every path, mode, symlink target and file content under
+// root
+func hashTree(root string) (string, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		fmt.Fprintf(h, "%s\x00%o\x00", rel, info.Mode())
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%s\x00", link)
+		case info.Mode().IsRegular():
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			h.Write(content)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,116 @@
+package dot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestTree(t *testing.T) string {
+	src := t.TempDir()
+	assert.Nil(t, os.Mkdir(filepath.Join(src, "bin"), 0750))
+	assert.Nil(t, os.WriteFile(filepath.Join(src, "config"), []byte("name: {{.Name}}\n"), 0600))
+	assert.Nil(t, os.WriteFile(filepath.Join(src, "bin/run"), []byte("#!/bin/sh\n"), 0755))
+	assert.Nil(t, os.WriteFile(filepath.Join(src, "logo.bin"), []byte("\x00{{.Name}}"), 0644))
+	assert.Nil(t, os.Symlink("config", filepath.Join(src, "link")))
+	return src
+}
+
+func fileMode(t *testing.T, path string) os.FileMode {
+	fileInfo, err := os.Stat(path)
+	assert.Nil(t, err)
+	return fileInfo.Mode().Perm()
+}
+
+func TestCopyTree(t *testing.T) {
+	src := newTestTree(t)
+	dst := filepath.Join(t.TempDir(), "app")
+	m := FileMapping{From: src, To: dst, As: "copy", With: map[string]string{"Name": "dot"}}
+	assert.False(t, m.isUpToDate(nil))
+
+	// text files are rendered, modes and symlinks kept
+	assert.Nil(t, m.doCopy())
+	assert.Equal(t, "name: dot\n", readString(t, filepath.Join(dst, "config")))
+	assert.Equal(t, "\x00{{.Name}}", readString(t, filepath.Join(dst, "logo.bin")))
+	assert.Equal(t, os.FileMode(0600), fileMode(t, filepath.Join(dst, "config")))
+	assert.Equal(t, os.FileMode(0755), fileMode(t, filepath.Join(dst, "bin/run")))
+	assert.Equal(t, os.FileMode(0750), fileMode(t, filepath.Join(dst, "bin")))
+	link, err := os.Readlink(filepath.Join(dst, "link"))
+	assert.Nil(t, err)
+	assert.Equal(t, "config", link)
+	assert.True(t, m.isUpToDate(nil))
+
+	// files no longer in the source are removed
+	assert.Nil(t, os.RemoveAll(filepath.Join(src, "bin")))
+	assert.Nil(t, os.WriteFile(filepath.Join(dst, "stale"), []byte("old"), 0644))
+	assert.Nil(t, os.Chmod(filepath.Join(dst, "config"), 0644))
+	changes, err := m.treeChanges()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"config", "bin", "stale"}, changes)
+	assert.False(t, m.isUpToDate(nil))
+
+	assert.Nil(t, m.doCopy())
+	assert.False(t, pathExists(filepath.Join(dst, "bin")))
+	assert.False(t, pathExists(filepath.Join(dst, "stale")))
+	assert.Equal(t, os.FileMode(0600), fileMode(t, filepath.Join(dst, "config")))
+	assert.True(t, m.isUpToDate(nil))
+}
+
+func TestApplyCopiesDirectory(t *testing.T) {
+	src := newTestTree(t)
+	dst := filepath.Join(t.TempDir(), "app")
+	d := Dots{FileMappings: []FileMapping{{From: src, To: dst, As: "copy"}}}
+	st := newTestState(t)
+
+	r := d.Apply(context.Background(), st, Options{})
+	assert.Empty(t, r.Failures)
+	assert.Equal(t, 1, r.Applied)
+	assert.True(t, st.owns(dst))
+	assert.Equal(t, []string{ActionUnchanged}, kinds(d.Plan(st, Options{})))
+	assert.Equal(t, StatusOk, d.Status(context.Background(), st)[0].Status)
+
+	// a new source file: dot's own copy is updated in place
+	assert.Nil(t, os.WriteFile(filepath.Join(src, "new"), []byte("new\n"), 0644))
+	assert.Equal(t, []string{ActionCopy}, kinds(d.Plan(st, Options{})))
+	s := d.Status(context.Background(), st)[0]
+	assert.Equal(t, StatusContentDiffers, s.Status)
+	assert.Equal(t, "differs in new", s.Detail)
+	diffs, errs := d.Diff(st)
+	assert.Empty(t, errs)
+	assert.Equal(t, []string{"--- " + dst + "/new (missing)\n+++ " + dst + "/new (from " + src + "/new)\n@@ -0,0 +1 @@\n+new\n"}, diffs)
+
+	r = d.Apply(context.Background(), st, Options{})
+	assert.Empty(t, r.Failures)
+	assert.Equal(t, "new\n", readString(t, filepath.Join(dst, "new")))
+	assert.Equal(t, []string{ActionUnchanged}, kinds(d.Plan(st, Options{})))
+}
+
+func TestRollbackCopyTreeInPlace(t *testing.T) {
+	src := newTestTree(t)
+	dst := filepath.Join(t.TempDir(), "app")
+	m := FileMapping{From: src, To: dst, As: "copy"}
+	assert.Nil(t, m.doCopy())
+	assert.Nil(t, os.WriteFile(filepath.Join(dst, "mine"), []byte("mine\n"), 0600))
+	assert.Nil(t, os.Chmod(filepath.Join(dst, "bin"), 0500))
+	defer func() {
+		_ = os.Chmod(filepath.Join(dst, "bin"), 0700)
+	}()
+
+	st := newTestState(t)
+	tx := begin(st, Options{}, "", newBackupStamp(t.TempDir()))
+	assert.Nil(t, tx.updating(dst))
+	assert.Nil(t, os.WriteFile(filepath.Join(src, "config"), []byte("changed\n"), 0600))
+	assert.Nil(t, m.doCopy())
+	assert.False(t, pathExists(filepath.Join(dst, "mine")))
+
+	assert.Empty(t, tx.rollback(0))
+	assert.Equal(t, "name: {{.Name}}\n", readString(t, filepath.Join(dst, "config")))
+	assert.Equal(t, "mine\n", readString(t, filepath.Join(dst, "mine")))
+	assert.Equal(t, os.FileMode(0500), fileMode(t, filepath.Join(dst, "bin")))
+	link, err := os.Readlink(filepath.Join(dst, "link"))
+	assert.Nil(t, err)
+	assert.Equal(t, "config", link)
+}
@@ -0,0 +1,107 @@
+package dot
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+ * variables: values shared by every template, from the dots file, the files
+ * it lists, and the command line
+ */
+
+// resolveVars merges the dots file's variables, from least to most
+// specific: `vars_files` in order, `vars`, the `vars_os` and `vars_host`
+// overlays that match this machine, and then Set
+func (dots Dots) resolveVars(opts Opts) (map[string]interface{}, []error) {
+	var errs []error
+	var layers []map[string]interface{}
+	for _, file := range dots.VarsFiles {
+		vars, err := readVarsFile(opts.sourcePath(file))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", file, err))
+			continue
+		}
+		layers = append(layers, vars)
+	}
+	layers = append(layers, dots.Vars)
+	for _, name := range sortedKeys(dots.VarsOs) {
+		if matchesOs(name) {
+			layers = append(layers, dots.VarsOs[name])
+		}
+	}
+	hostname := GatherFacts().Hostname
+	short, _, _ := strings.Cut(hostname, ".")
+	for _, name := range sortedKeys(dots.VarsHost) {
+		if name == hostname || name == short {
+			layers = append(layers, dots.VarsHost[name])
+		}
+	}
+	layers = append(layers, dots.Set)
+	return mergeVars(layers...), errs
+}
+
+func readVarsFile(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var vars map[string]interface{}
+	if err := yaml.Unmarshal(data, &vars); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+func sortedKeys(m map[string]map[string]interface{}) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// mergeVars merges layers of variables into a new set, later ones taking
+// precedence; maps in more than one layer are merged key by key, anything
+// else is replaced
+func mergeVars(layers ...map[string]interface{}) map[string]interface{} {
+	merged := map[string]interface{}{}
+	for _, layer := range layers {
+		for name, value := range layer {
+			if inner, ok := value.(map[string]interface{}); ok {
+				below, _ := merged[name].(map[string]interface{})
+				value = mergeVars(below, inner)
+			}
+			merged[name] = value
+		}
+	}
+	return merged
+}
+
+// ParseVars reads variables as set on the command line, `name=value`: the
+// value is YAML, so it may be a number, a list or a map as well as a string,
+// and a dotted name sets a key in a map
+func ParseVars(assignments []string) (map[string]interface{}, error) {
+	var layers []map[string]interface{}
+	for _, assignment := range assignments {
+		name, text, ok := strings.Cut(assignment, "=")
+		if !ok || len(name) == 0 {
+			return nil, fmt.Errorf("%s: variables are set as name=value", assignment)
+		}
+		var value interface{}
+		if err := yaml.Unmarshal([]byte(text), &value); err != nil || value == nil {
+			value = text
+		}
+		keys := strings.Split(name, ".")
+		for i := len(keys) - 1; i > 0; i-- {
+			value = map[string]interface{}{keys[i]: value}
+		}
+		layers = append(layers, map[string]interface{}{keys[0]: value})
+	}
+	return mergeVars(layers...), nil
+}
@@ -0,0 +1,111 @@
+package dot
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVars(t *testing.T) {
+	vars, err := ParseVars([]string{"email=me@home.org", "jobs=4", "git.sign=true", "git.key=ABCD", "shells=[zsh, bash]", "empty="})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"email":  "me@home.org",
+		"jobs":   4,
+		"git":    map[string]interface{}{"sign": true, "key": "ABCD"},
+		"shells": []interface{}{"zsh", "bash"},
+		"empty":  "",
+	}, vars)
+
+	_, err = ParseVars([]string{"email"})
+	assert.Equal(t, "email: variables are set as name=value", err.Error())
+	_, err = ParseVars([]string{"=x"})
+	assert.NotNil(t, err)
+}
+
+func TestMergeVars(t *testing.T) {
+	base := map[string]interface{}{
+		"git":   map[string]interface{}{"name": "me", "email": "me@home.org"},
+		"paths": []interface{}{"/usr/bin"},
+	}
+	over := map[string]interface{}{
+		"git":   map[string]interface{}{"email": "me@work.com"},
+		"paths": []interface{}{"/opt/homebrew/bin"},
+	}
+	assert.Equal(t, map[string]interface{}{
+		"git":   map[string]interface{}{"name": "me", "email": "me@work.com"},
+		"paths": []interface{}{"/opt/homebrew/bin"},
+	}, mergeVars(base, over))
+	// left as they were
+	assert.Equal(t, "me@home.org", base["git"].(map[string]interface{})["email"])
+}
+
+func TestResolveVars(t *testing.T) {
+	root := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(root, "vars.yml"), []byte("email: me@home.org\nbrew: /usr/local\neditor: vi\nshell: sh\n"), 0644))
+	otherOs := "macos"
+	if runtime.GOOS == "darwin" {
+		otherOs = "linux"
+	}
+
+	dots, err := Decode([]byte(`
+vars_files:
+  - vars.yml
+vars:
+  editor: nvim
+  shell: bash
+vars_os:
+  ` + otherOs + `:
+    brew: /opt/homebrew
+  all:
+    shell: fish
+vars_host:
+  ` + GatherFacts().Hostname + `:
+    email: me@work.com
+    shell: zsh
+`))
+	assert.Nil(t, err)
+	dots.Opts.Cd = root
+	dots.Set = map[string]interface{}{"editor": "emacs"}
+	vars, errs := dots.resolveVars(dots.Opts)
+	assert.Empty(t, errs)
+	assert.Equal(t, map[string]interface{}{
+		"email":  "me@work.com",
+		"brew":   "/usr/local",
+		"editor": "emacs",
+		"shell":  "zsh",
+	}, vars)
+
+	dots.VarsFiles = []string{"nothere.yml"}
+	_, errs = dots.resolveVars(dots.Opts)
+	assert.Equal(t, 1, len(errs))
+}
+
+func TestVarsInTemplates(t *testing.T) {
+	root := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(root, "gitconfig"), []byte("[user]\n  email = {{ .email }}\n{{ range .signers }}  signer = {{ . }}\n{{ end }}"), 0644))
+
+	dots, err := Decode([]byte(`
+vars:
+  email: me@home.org
+  signers: [a, b]
+map:
+  gitconfig:
+    as: copy
+    with:
+      first: '{{ index .signers 0 }}'
+`))
+	assert.Nil(t, err)
+	dots.Opts.Cd = root
+	d, errs := dots.Transform()
+	assert.Empty(t, errs)
+	assert.Equal(t, "a", d.FileMappings[0].With["first"])
+	assert.Equal(t, "me@home.org", d.Vars["email"])
+
+	content, err := d.FileMappings[0].content()
+	assert.Nil(t, err)
+	assert.Equal(t, "[user]\n  email = me@home.org\n  signer = a\n  signer = b\n", string(content))
+}